@@ -3,14 +3,20 @@ package main
 import (
     "log"
     "net/http"
+    "os"
+    "time"
 
     "github.com/gorilla/mux"
     "github.com/halizadz/chat-app-backend/internal/config"
     "github.com/halizadz/chat-app-backend/internal/database"
     "github.com/halizadz/chat-app-backend/internal/handlers"
+    "github.com/halizadz/chat-app-backend/internal/linkpreview"
     "github.com/halizadz/chat-app-backend/internal/middleware"
     "github.com/halizadz/chat-app-backend/internal/repository"
+    "github.com/halizadz/chat-app-backend/internal/scanner"
+    "github.com/halizadz/chat-app-backend/internal/storage"
     "github.com/halizadz/chat-app-backend/internal/websocket"
+    "github.com/halizadz/chat-app-backend/migrations"
 )
 
 func main() {
@@ -25,34 +31,160 @@ func main() {
     }
     defer db.Close()
 
+    migrator, err := database.NewMigrator(db.DB, migrations.FS)
+    if err != nil {
+        log.Fatal("Error loading migrations:", err)
+    }
+
+    // `server migrate ...` manages the schema and exits
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        runMigrate(migrator, os.Args[2:])
+        return
+    }
+
+    // Replicas starting together wait on the migration lock, so only one
+    // applies each migration
+    if cfg.AutoMigrate {
+        applied, err := migrator.Up()
+        if err != nil {
+            log.Fatal("Error applying migrations:", err)
+        }
+        if applied > 0 {
+            log.Printf("Applied %d migration(s)", applied)
+        }
+    }
+
     userRepo := repository.NewUserRepository(db.DB)
     roomRepo := repository.NewRoomRepository(db.DB)
     messageRepo := repository.NewMessageRepository(db.DB)
+    sessionRepo := repository.NewSessionRepository(db.DB)
+    invitationRepo := repository.NewInvitationRepository(db.DB)
+    attachmentRepo := repository.NewAttachmentRepository(db.DB)
+    uploadRepo := repository.NewUploadRepository(db.DB)
+    linkPreviewRepo := repository.NewLinkPreviewRepository(db.DB)
+
+    // Redis lets several replicas share room broadcasts and presence
+    var broker websocket.Broker
+    var presenceStore websocket.PresenceStore
+    switch cfg.Broker {
+    case "redis":
+        redisBroker, err := websocket.NewRedisBroker(cfg.RedisURL, "chat:events")
+        if err != nil {
+            log.Fatal("Error connecting to redis:", err)
+        }
+        redisPresence, err := websocket.NewRedisPresenceStore(cfg.RedisURL)
+        if err != nil {
+            log.Fatal("Error connecting to redis:", err)
+        }
+        defer redisPresence.Close()
+        broker = redisBroker
+        presenceStore = redisPresence
+        log.Printf("Using redis broker at %s", cfg.RedisURL)
+    case "memory":
+        broker = websocket.NewMemoryBroker()
+        presenceStore = websocket.NewMemoryPresenceStore()
+    default:
+        log.Fatalf("Unknown broker %q", cfg.Broker)
+    }
+    defer broker.Close()
+
+    signer := storage.NewSigner(cfg.SigningKey)
+
+    // S3 lets every replica read files uploaded through any other
+    var store storage.Storage
+    switch cfg.Storage {
+    case "s3":
+        s3Storage, err := storage.NewS3Storage(storage.S3Config{
+            Endpoint:  cfg.S3Endpoint,
+            Region:    cfg.S3Region,
+            Bucket:    cfg.S3Bucket,
+            AccessKey: cfg.S3AccessKey,
+            SecretKey: cfg.S3SecretKey,
+            PathStyle: cfg.S3PathStyle,
+        })
+        if err != nil {
+            log.Fatal("Error configuring s3 storage:", err)
+        }
+        store = s3Storage
+        log.Printf("Storing uploads in s3 bucket %s", cfg.S3Bucket)
+    case "local":
+        localStorage, err := storage.NewLocalStorage(cfg.UploadDir, "/uploads", signer)
+        if err != nil {
+            log.Fatal("Error creating upload directory:", err)
+        }
+        store = localStorage
+    default:
+        log.Fatalf("Unknown storage %q", cfg.Storage)
+    }
 
-    hub := websocket.NewHub()
+    // Uploads are scanned as they are stored; flagged files are quarantined
+    var fileScanner scanner.Scanner
+    switch cfg.Scanner {
+    case "clamd":
+        clamd, err := scanner.NewClamdScanner(cfg.ClamdAddress, cfg.ScanTimeout)
+        if err != nil {
+            log.Fatal("Error configuring clamd scanner:", err)
+        }
+        fileScanner = clamd
+        log.Printf("Scanning uploads with clamd at %s", cfg.ClamdAddress)
+    case "none":
+        fileScanner = scanner.NewNoopScanner()
+    default:
+        log.Fatalf("Unknown scanner %q", cfg.Scanner)
+    }
+
+    hub := websocket.NewHub(broker)
+    presence := websocket.NewPresence(hub, userRepo, roomRepo, presenceStore, websocket.PresenceIdleAfter)
     go hub.Run()
+    go presence.Run()
 
-    authHandler := handlers.NewAuthHandler(userRepo, cfg.JWTSecret)
-    chatHandler := handlers.NewChatHandler(roomRepo, messageRepo, userRepo)
-    wsHandler := handlers.NewWebSocketHandler(hub, roomRepo, messageRepo, cfg.JWTSecret)
-    fileHandler := handlers.NewFileHandler("./uploads")
+    var previewer *handlers.LinkPreviewer
+    if cfg.LinkPreviews {
+        previewer = handlers.NewLinkPreviewer(linkpreview.NewFetcher(), linkPreviewRepo, messageRepo, hub)
+        go previewer.Run()
+    }
+
+    trustedProxies, err := handlers.ParseTrustedProxies(cfg.TrustedProxies)
+    if err != nil {
+        log.Fatal("Error reading TRUSTED_PROXIES:", err)
+    }
+
+    authHandler := handlers.NewAuthHandler(userRepo, sessionRepo, hub, cfg.JWTSecret, cfg.AccessTokenTTL, cfg.RefreshTokenTTL, trustedProxies)
+    chatHandler := handlers.NewChatHandler(roomRepo, messageRepo, userRepo, invitationRepo, attachmentRepo, hub, cfg.RoomStorageQuota, previewer)
+    wsHandler := handlers.NewWebSocketHandler(hub, roomRepo, messageRepo, attachmentRepo, sessionRepo, cfg.JWTSecret, cfg.RoomStorageQuota, previewer)
+    fileHandler := handlers.NewFileHandler(store, fileScanner, attachmentRepo, uploadRepo, roomRepo, signer, handlers.UploadPolicy{
+        MaxUploadSize: cfg.MaxUploadSize,
+        UploadExpiry:  cfg.UploadExpiry,
+        AllowedTypes:  cfg.AllowedUploadTypes,
+        UserQuota:     cfg.UserStorageQuota,
+    })
     userHandler := handlers.NewUserHandler(userRepo)
 
+    go fileHandler.RunUploadCleanup(time.Hour)
+
     r := mux.NewRouter()
     r.Use(middleware.CORS)
 
     // Public routes
     r.HandleFunc("/api/auth/register", authHandler.Register).Methods("POST", "OPTIONS")
     r.HandleFunc("/api/auth/login", authHandler.Login).Methods("POST", "OPTIONS")
+    r.HandleFunc("/api/auth/refresh", authHandler.Refresh).Methods("POST", "OPTIONS")
 
     // WebSocket route - TANPA auth middleware, karena token di query param
+    r.HandleFunc("/api/ws", wsHandler.HandleWebSocket).Methods("GET")
     r.HandleFunc("/api/ws/{roomId}", wsHandler.HandleWebSocket).Methods("GET")
 
-    r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads"))))
+    // Signed links carry their own authorization
+    r.HandleFunc("/uploads/{key:.+}", fileHandler.ServeUpload).Methods("GET")
+    r.HandleFunc("/api/attachments/{attachmentId}/download", fileHandler.DownloadSigned).Methods("GET")
 
     // Protected routes
     api := r.PathPrefix("/api").Subrouter()
-    api.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+    api.Use(middleware.AuthMiddleware(cfg.JWTSecret, sessionRepo))
+
+    api.HandleFunc("/auth/logout", authHandler.Logout).Methods("POST", "OPTIONS")
+    api.HandleFunc("/auth/sessions", authHandler.ListSessions).Methods("GET", "OPTIONS")
+    api.HandleFunc("/auth/sessions/{sessionId}", authHandler.TerminateSession).Methods("DELETE", "OPTIONS")
 
     api.HandleFunc("/users", userHandler.GetAllUsers).Methods("GET", "OPTIONS")
     api.HandleFunc("/users/me", userHandler.GetUserProfile).Methods("GET", "OPTIONS")
@@ -60,29 +192,61 @@ func main() {
     api.HandleFunc("/users", userHandler.GetAllUsers).Methods("GET", "OPTIONS")
     api.HandleFunc("/users/me", userHandler.GetUserProfile).Methods("GET", "OPTIONS")
     api.HandleFunc("/users/me", userHandler.UpdateUserProfile).Methods("PUT", "OPTIONS")
+    api.HandleFunc("/users/me/storage", fileHandler.GetStorageUsage).Methods("GET", "OPTIONS")
 
     api.HandleFunc("/rooms", chatHandler.GetUserRooms).Methods("GET", "OPTIONS")
     api.HandleFunc("/rooms", chatHandler.CreateRoom).Methods("POST", "OPTIONS")
     api.HandleFunc("/rooms/private", chatHandler.CreateOrGetPrivateRoom).Methods("POST", "OPTIONS")
+    api.HandleFunc("/rooms/discover", chatHandler.DiscoverRooms).Methods("GET", "OPTIONS")
     api.HandleFunc("/rooms/{roomId}", chatHandler.GetRoom).Methods("GET", "OPTIONS")
     api.HandleFunc("/rooms/{roomId}", chatHandler.UpdateRoom).Methods("PUT", "OPTIONS")
     api.HandleFunc("/rooms/{roomId}", chatHandler.DeleteRoom).Methods("DELETE", "OPTIONS")
     api.HandleFunc("/rooms/{roomId}/messages", chatHandler.GetRoomMessages).Methods("GET", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/messages", chatHandler.SendMessage).Methods("POST", "OPTIONS")
     api.HandleFunc("/rooms/{roomId}/messages/search", chatHandler.SearchMessages).Methods("GET", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/files", chatHandler.GetRoomFiles).Methods("GET", "OPTIONS")
     api.HandleFunc("/rooms/{roomId}/read", chatHandler.MarkRoomAsRead).Methods("POST", "OPTIONS")
     api.HandleFunc("/rooms/{roomId}/members", chatHandler.GetRoomMembers).Methods("GET", "OPTIONS")
     api.HandleFunc("/rooms/{roomId}/members", chatHandler.AddRoomMember).Methods("POST", "OPTIONS")
     api.HandleFunc("/rooms/{roomId}/members/{userId}", chatHandler.RemoveRoomMember).Methods("DELETE", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/members/{userId}/role", chatHandler.UpdateMemberRole).Methods("PUT", "OPTIONS")
     api.HandleFunc("/rooms/{roomId}/leave", chatHandler.LeaveRoom).Methods("POST", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/join", chatHandler.JoinRoom).Methods("POST", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/bans", chatHandler.GetBans).Methods("GET", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/bans", chatHandler.BanMember).Methods("POST", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/bans/{userId}", chatHandler.UnbanMember).Methods("DELETE", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/invitations", chatHandler.GetRoomInvitations).Methods("GET", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/invitations/{invitationId}", chatHandler.CancelInvitation).Methods("DELETE", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/invite-links", chatHandler.GetInviteLinks).Methods("GET", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/invite-links", chatHandler.CreateInviteLink).Methods("POST", "OPTIONS")
+    api.HandleFunc("/rooms/{roomId}/invite-links/{linkId}", chatHandler.RevokeInviteLink).Methods("DELETE", "OPTIONS")
+
+    api.HandleFunc("/invitations", chatHandler.GetMyInvitations).Methods("GET", "OPTIONS")
+    api.HandleFunc("/invitations/{invitationId}/accept", chatHandler.AcceptInvitation).Methods("POST", "OPTIONS")
+    api.HandleFunc("/invitations/{invitationId}/decline", chatHandler.DeclineInvitation).Methods("POST", "OPTIONS")
+    api.HandleFunc("/invites/{code}/accept", chatHandler.AcceptInviteLink).Methods("POST", "OPTIONS")
+
+    api.HandleFunc("/search/messages", chatHandler.SearchAllMessages).Methods("GET", "OPTIONS")
 
     api.HandleFunc("/messages/{messageId}", chatHandler.UpdateMessage).Methods("PUT", "OPTIONS")
     api.HandleFunc("/messages/{messageId}", chatHandler.DeleteMessage).Methods("DELETE", "OPTIONS")
+    api.HandleFunc("/messages/{messageId}/thread", chatHandler.GetThread).Methods("GET", "OPTIONS")
+    api.HandleFunc("/messages/{messageId}/reactions", chatHandler.AddReaction).Methods("POST", "OPTIONS")
+    api.HandleFunc("/messages/{messageId}/reactions", chatHandler.RemoveReaction).Methods("DELETE", "OPTIONS")
 
     api.HandleFunc("/upload", fileHandler.UploadFile).Methods("POST", "OPTIONS")
+    api.HandleFunc("/uploads", fileHandler.CreateUpload).Methods("POST", "OPTIONS")
+    api.HandleFunc("/uploads/{uploadId}", fileHandler.GetUpload).Methods("GET", "OPTIONS")
+    api.HandleFunc("/uploads/{uploadId}", fileHandler.UploadChunk).Methods("PUT", "OPTIONS")
+    api.HandleFunc("/uploads/{uploadId}", fileHandler.CancelUpload).Methods("DELETE", "OPTIONS")
+    api.HandleFunc("/uploads/{uploadId}/complete", fileHandler.CompleteUpload).Methods("POST", "OPTIONS")
+    api.HandleFunc("/attachments/{attachmentId}", fileHandler.GetAttachment).Methods("GET", "OPTIONS")
+    api.HandleFunc("/attachments/{attachmentId}/url", fileHandler.GetAttachmentURL).Methods("GET", "OPTIONS")
+    api.HandleFunc("/attachments/{attachmentId}/thumbnails/{size}", fileHandler.GetThumbnail).Methods("GET", "OPTIONS")
 
     log.Printf("Server starting on port %s", cfg.Port)
     log.Printf("CORS enabled for all origins")
-    log.Printf("WebSocket available at ws://localhost:%s/api/ws/{roomId}?token=...", cfg.Port)
+    log.Printf("WebSocket available at ws://localhost:%s/api/ws?token=...", cfg.Port)
     
     if err := http.ListenAndServe(":"+cfg.Port, r); err != nil {
         log.Fatal("Error starting server:", err)
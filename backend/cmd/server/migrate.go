@@ -0,0 +1,58 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "strconv"
+
+    "github.com/halizadz/chat-app-backend/internal/database"
+)
+
+// runMigrate handles `server migrate [up | down [n] | status]`
+func runMigrate(migrator *database.Migrator, args []string) {
+    command := "up"
+    if len(args) > 0 {
+        command = args[0]
+    }
+
+    switch command {
+    case "up":
+        applied, err := migrator.Up()
+        if err != nil {
+            log.Fatal("Error applying migrations:", err)
+        }
+        log.Printf("Applied %d migration(s)", applied)
+
+    case "down":
+        steps := 1
+        if len(args) > 1 {
+            n, err := strconv.Atoi(args[1])
+            if err != nil || n < 1 {
+                log.Fatalf("Invalid number of migrations to revert: %q", args[1])
+            }
+            steps = n
+        }
+
+        reverted, err := migrator.Down(steps)
+        if err != nil {
+            log.Fatal("Error reverting migrations:", err)
+        }
+        log.Printf("Reverted %d migration(s)", reverted)
+
+    case "status":
+        statuses, err := migrator.Status()
+        if err != nil {
+            log.Fatal("Error reading migration status:", err)
+        }
+        for _, status := range statuses {
+            applied := "pending"
+            if status.AppliedAt != nil {
+                applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+            }
+            fmt.Printf("%03d_%-30s %s\n", status.Version, status.Name, applied)
+        }
+
+    default:
+        log.Fatalf("Unknown migrate command %q (expected up, down or status)", command)
+    }
+}
@@ -2,6 +2,10 @@ package config
 
 import (
     "os"
+    "strconv"
+    "strings"
+    "time"
+
     "github.com/joho/godotenv"
 )
 
@@ -9,19 +13,129 @@ type Config struct {
     Port         string
     DatabaseURL  string
     RedisURL     string
+    Broker       string // memory or redis
     JWTSecret    string
     Environment  string
+
+    // Reverse proxies, as IPs or CIDRs, whose X-Forwarded-For and X-Real-IP
+    // headers are believed when recording where a session signed in from
+    TrustedProxies []string
+
+    // Lifetimes of access tokens and of the refresh tokens that renew them
+    AccessTokenTTL  time.Duration
+    RefreshTokenTTL time.Duration
+
+    // Apply pending schema migrations when the server starts
+    AutoMigrate bool
+
+    // Where uploads are kept: local or s3
+    Storage    string
+    UploadDir  string
+    SigningKey string // Signs download links
+
+    // Largest file a chunked upload may create, and how long an abandoned
+    // one is kept
+    MaxUploadSize int64
+    UploadExpiry  time.Duration
+
+    // Content types uploads may have, as sniffed from their contents;
+    // entries like image/* match a whole family
+    AllowedUploadTypes []string
+
+    // Default storage limits in bytes, unless a user or room has its own;
+    // 0 means unlimited
+    UserStorageQuota int64
+    RoomStorageQuota int64
+
+    // Malware scanning of uploads: none or clamd
+    Scanner      string
+    ClamdAddress string
+    ScanTimeout  time.Duration
+
+    // Fetch previews of links posted in messages
+    LinkPreviews bool
+
+    S3Endpoint  string
+    S3Region    string
+    S3Bucket    string
+    S3AccessKey string
+    S3SecretKey string
+    S3PathStyle bool // Set for MinIO and other self-hosted servers
+}
+
+// Images, audio, video, PDFs, plain text, office documents and archives
+var defaultUploadTypes = []string{
+    "image/png",
+    "image/jpeg",
+    "image/gif",
+    "image/webp",
+    "image/bmp",
+    "audio/*",
+    "video/*",
+    "application/ogg",
+    "application/pdf",
+    "text/plain",
+    "text/csv",
+    "text/markdown",
+    "application/json",
+    "application/msword",
+    "application/vnd.ms-excel",
+    "application/vnd.ms-powerpoint",
+    "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+    "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+    "application/vnd.oasis.opendocument.text",
+    "application/vnd.oasis.opendocument.spreadsheet",
+    "application/vnd.oasis.opendocument.presentation",
+    "application/epub+zip",
+    "application/zip",
+    "application/x-gzip",
+    "application/x-rar-compressed",
 }
 
 func Load() (*Config, error) {
     godotenv.Load()
 
+    jwtSecret := getEnv("JWT_SECRET", "your-secret-key")
+
     return &Config{
         Port:        getEnv("PORT", "8080"),
         DatabaseURL: getEnv("DATABASE_URL", ""),
         RedisURL:    getEnv("REDIS_URL", "localhost:6379"),
-        JWTSecret:   getEnv("JWT_SECRET", "your-secret-key"),
+        Broker:      getEnv("BROKER", "memory"),
+        JWTSecret:   jwtSecret,
         Environment: getEnv("ENVIRONMENT", "development"),
+
+        TrustedProxies: getList("TRUSTED_PROXIES", nil),
+
+        AccessTokenTTL:  getDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+        RefreshTokenTTL: getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+        AutoMigrate: getBool("AUTO_MIGRATE", true),
+
+        Storage:    getEnv("STORAGE", "local"),
+        UploadDir:  getEnv("UPLOAD_DIR", "./uploads"),
+        SigningKey: getEnv("STORAGE_SIGNING_KEY", jwtSecret),
+
+        MaxUploadSize: getInt64("MAX_UPLOAD_SIZE", 2<<30),
+        UploadExpiry:  getDuration("UPLOAD_EXPIRY", 24*time.Hour),
+
+        AllowedUploadTypes: getList("ALLOWED_UPLOAD_TYPES", defaultUploadTypes),
+        UserStorageQuota:   getInt64("USER_STORAGE_QUOTA", 10<<30),
+        RoomStorageQuota:   getInt64("ROOM_STORAGE_QUOTA", 50<<30),
+
+        Scanner:      getEnv("SCANNER", "none"),
+        ClamdAddress: getEnv("CLAMD_ADDRESS", "tcp://localhost:3310"),
+        ScanTimeout:  getDuration("SCAN_TIMEOUT", 30*time.Second),
+
+        LinkPreviews: getBool("LINK_PREVIEWS", true),
+
+        S3Endpoint:  getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+        S3Region:    getEnv("S3_REGION", "us-east-1"),
+        S3Bucket:    getEnv("S3_BUCKET", ""),
+        S3AccessKey: getEnv("S3_ACCESS_KEY", ""),
+        S3SecretKey: getEnv("S3_SECRET_KEY", ""),
+        S3PathStyle: getBool("S3_PATH_STYLE", false),
     }, nil
 }
 
@@ -30,4 +144,47 @@ func getEnv(key, defaultValue string) string {
         return value
     }
     return defaultValue
-}
\ No newline at end of file
+}
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+    if value := os.Getenv(key); value != "" {
+        if d, err := time.ParseDuration(value); err == nil {
+            return d
+        }
+    }
+    return defaultValue
+}
+
+func getBool(key string, defaultValue bool) bool {
+    if value := os.Getenv(key); value != "" {
+        if b, err := strconv.ParseBool(value); err == nil {
+            return b
+        }
+    }
+    return defaultValue
+}
+
+func getInt64(key string, defaultValue int64) int64 {
+    if value := os.Getenv(key); value != "" {
+        if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+            return n
+        }
+    }
+    return defaultValue
+}
+
+// getList splits a comma-separated variable, skipping empty entries
+func getList(key string, defaultValue []string) []string {
+    value := os.Getenv(key)
+    if value == "" {
+        return defaultValue
+    }
+
+    var list []string
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            list = append(list, item)
+        }
+    }
+    return list
+}
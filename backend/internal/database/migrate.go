@@ -0,0 +1,251 @@
+package database
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "io/fs"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// migrationLockKey is the advisory lock held while migrating, so replicas
+// starting together apply each migration exactly once
+const migrationLockKey int64 = 0x636861746170 // "chatap"
+
+// Migration is one schema version with the SQL that applies and reverts it
+type Migration struct {
+    Version int
+    Name    string
+    Up      string
+    Down    string // Empty if the migration cannot be reverted
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+    Migration
+    AppliedAt *time.Time
+}
+
+type Migrator struct {
+    db         *sql.DB
+    migrations []Migration
+}
+
+// NewMigrator loads migrations from fsys. Files are named NNN_name.sql,
+// with an optional NNN_name.down.sql that reverts them.
+func NewMigrator(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+    migrations, err := loadMigrations(fsys)
+    if err != nil {
+        return nil, err
+    }
+    return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+    names, err := fs.Glob(fsys, "*.sql")
+    if err != nil {
+        return nil, err
+    }
+
+    byVersion := make(map[int]*Migration)
+    for _, file := range names {
+        base, down := strings.CutSuffix(strings.TrimSuffix(file, ".sql"), ".down")
+
+        prefix, name, ok := strings.Cut(base, "_")
+        version, err := strconv.Atoi(prefix)
+        if !ok || err != nil {
+            return nil, fmt.Errorf("migration %s must be named NNN_name.sql", file)
+        }
+
+        data, err := fs.ReadFile(fsys, file)
+        if err != nil {
+            return nil, fmt.Errorf("error reading migration %s: %w", file, err)
+        }
+
+        m, ok := byVersion[version]
+        if !ok {
+            m = &Migration{Version: version, Name: name}
+            byVersion[version] = m
+        } else if m.Name != name {
+            return nil, fmt.Errorf("migrations %03d_%s and %03d_%s share a version", version, m.Name, version, name)
+        }
+
+        if down {
+            m.Down = string(data)
+        } else {
+            m.Up = string(data)
+        }
+    }
+
+    migrations := make([]Migration, 0, len(byVersion))
+    for _, m := range byVersion {
+        if m.Up == "" {
+            return nil, fmt.Errorf("migration %03d_%s has no up file", m.Version, m.Name)
+        }
+        migrations = append(migrations, *m)
+    }
+    sort.Slice(migrations, func(i, j int) bool {
+        return migrations[i].Version < migrations[j].Version
+    })
+
+    return migrations, nil
+}
+
+// withLock runs fn on a single connection holding the migration lock
+func (m *Migrator) withLock(fn func(conn *sql.Conn) error) error {
+    ctx := context.Background()
+
+    conn, err := m.db.Conn(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    // Blocks until any other replica finishes migrating
+    if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+        return fmt.Errorf("error acquiring migration lock: %w", err)
+    }
+    defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+    _, err = conn.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version BIGINT PRIMARY KEY,
+            name TEXT NOT NULL,
+            applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+        )
+    `)
+    if err != nil {
+        return fmt.Errorf("error creating schema_migrations: %w", err)
+    }
+
+    return fn(conn)
+}
+
+// applied returns when each applied version was applied
+func applied(conn *sql.Conn) (map[int]time.Time, error) {
+    rows, err := conn.QueryContext(context.Background(), `SELECT version, applied_at FROM schema_migrations`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    versions := make(map[int]time.Time)
+    for rows.Next() {
+        var version int
+        var appliedAt time.Time
+        if err := rows.Scan(&version, &appliedAt); err != nil {
+            return nil, err
+        }
+        versions[version] = appliedAt
+    }
+
+    return versions, rows.Err()
+}
+
+// run executes a migration's SQL and records the change in one transaction
+func run(conn *sql.Conn, script, record string, args ...interface{}) error {
+    ctx := context.Background()
+
+    tx, err := conn.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.ExecContext(ctx, script); err != nil {
+        return err
+    }
+    if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// Up applies every pending migration in version order and returns how many
+// were applied
+func (m *Migrator) Up() (int, error) {
+    count := 0
+    err := m.withLock(func(conn *sql.Conn) error {
+        done, err := applied(conn)
+        if err != nil {
+            return err
+        }
+
+        for _, migration := range m.migrations {
+            if _, ok := done[migration.Version]; ok {
+                continue
+            }
+
+            log.Printf("Applying migration %03d_%s", migration.Version, migration.Name)
+            err := run(conn, migration.Up,
+                `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+                migration.Version, migration.Name, time.Now())
+            if err != nil {
+                return fmt.Errorf("error applying migration %03d_%s: %w", migration.Version, migration.Name, err)
+            }
+            count++
+        }
+        return nil
+    })
+    return count, err
+}
+
+// Down reverts the most recently applied migrations, newest first, and
+// returns how many were reverted
+func (m *Migrator) Down(steps int) (int, error) {
+    count := 0
+    err := m.withLock(func(conn *sql.Conn) error {
+        done, err := applied(conn)
+        if err != nil {
+            return err
+        }
+
+        for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+            migration := m.migrations[i]
+            if _, ok := done[migration.Version]; !ok {
+                continue
+            }
+
+            if migration.Down == "" {
+                return fmt.Errorf("migration %03d_%s cannot be reverted", migration.Version, migration.Name)
+            }
+
+            log.Printf("Reverting migration %03d_%s", migration.Version, migration.Name)
+            err := run(conn, migration.Down,
+                `DELETE FROM schema_migrations WHERE version = $1`,
+                migration.Version)
+            if err != nil {
+                return fmt.Errorf("error reverting migration %03d_%s: %w", migration.Version, migration.Name, err)
+            }
+            count++
+        }
+        return nil
+    })
+    return count, err
+}
+
+// Status lists every known migration and when it was applied
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+    var statuses []MigrationStatus
+    err := m.withLock(func(conn *sql.Conn) error {
+        done, err := applied(conn)
+        if err != nil {
+            return err
+        }
+
+        for _, migration := range m.migrations {
+            status := MigrationStatus{Migration: migration}
+            if appliedAt, ok := done[migration.Version]; ok {
+                status.AppliedAt = &appliedAt
+            }
+            statuses = append(statuses, status)
+        }
+        return nil
+    })
+    return statuses, err
+}
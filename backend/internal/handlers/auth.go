@@ -2,25 +2,43 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 	"unicode"
 
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/halizadz/chat-app-backend/internal/middleware"
 	"github.com/halizadz/chat-app-backend/internal/models"
 	"github.com/halizadz/chat-app-backend/internal/repository"
 	"github.com/halizadz/chat-app-backend/internal/utils"
+	ws "github.com/halizadz/chat-app-backend/internal/websocket"
 )
 
 type AuthHandler struct {
-	userRepo  *repository.UserRepository
-	jwtSecret string
+	userRepo    *repository.UserRepository
+	sessionRepo *repository.SessionRepository
+	hub         *ws.Hub
+	jwtSecret   string
+	accessTTL   time.Duration
+	refreshTTL  time.Duration
+	proxies     []*net.IPNet
 }
 
-func NewAuthHandler(userRepo *repository.UserRepository, jwtSecret string) *AuthHandler {
+func NewAuthHandler(userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, hub *ws.Hub, jwtSecret string, accessTTL, refreshTTL time.Duration, proxies []*net.IPNet) *AuthHandler {
 	return &AuthHandler{
-		userRepo:  userRepo,
-		jwtSecret: jwtSecret,
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
+		hub:         hub,
+		jwtSecret:   jwtSecret,
+		accessTTL:   accessTTL,
+		refreshTTL:  refreshTTL,
+		proxies:     proxies,
 	}
 }
 
@@ -35,9 +53,46 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  *models.User `json:"user"`
+	Token        string       `json:"token"`
+	ExpiresAt    time.Time    `json:"expires_at"`
+	RefreshToken string       `json:"refresh_token"`
+	User         *models.User `json:"user"`
+}
+
+// issueTokens starts a new session for the user on the requesting device
+// and returns its tokens
+func (h *AuthHandler) issueTokens(user *models.User, r *http.Request) (*AuthResponse, error) {
+	refreshToken, refreshHash, err := utils.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.Session{
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IPAddress: clientIP(r, h.proxies),
+		ExpiresAt: time.Now().Add(h.refreshTTL),
+	}
+	if err := h.sessionRepo.Create(session, refreshHash); err != nil {
+		return nil, err
+	}
+
+	token, err := utils.GenerateToken(user.ID, user.Username, user.Email, session.ID, h.accessTTL, h.jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthResponse{
+		Token:        token,
+		ExpiresAt:    time.Now().Add(h.accessTTL),
+		RefreshToken: refreshToken,
+		User:         user,
+	}, nil
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -130,21 +185,18 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate token
-	token, err := utils.GenerateToken(user.ID, user.Username, user.Email, h.jwtSecret)
+	// Clear password hash before sending
+	user.PasswordHash = ""
+
+	// Generate tokens
+	response, err := h.issueTokens(user, r)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	// Clear password hash before sending
-	user.PasswordHash = ""
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(AuthResponse{
-		Token: token,
-		User:  user,
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -167,22 +219,215 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate token
-	token, err := utils.GenerateToken(user.ID, user.Username, user.Email, h.jwtSecret)
+	// Clear password hash before sending
+	user.PasswordHash = ""
+
+	// Generate tokens
+	response, err := h.issueTokens(user, r)
 	if err != nil {
 		http.Error(w, "Error generating token", http.StatusInternalServerError)
 		return
 	}
 
-	// Update user status to online
-	h.userRepo.UpdateStatus(user.ID, "online")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token; the old refresh token stops working
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	refreshToken, refreshHash, err := utils.GenerateRefreshToken()
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
+
+	session, err := h.sessionRepo.Rotate(utils.HashToken(req.RefreshToken), refreshHash, time.Now().Add(h.refreshTTL))
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) || errors.Is(err, repository.ErrSessionExpired) || errors.Is(err, repository.ErrRefreshTokenReused) {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Error refreshing session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.userRepo.FindByID(session.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := utils.GenerateToken(user.ID, user.Username, user.Email, session.ID, h.accessTTL, h.jwtSecret)
+	if err != nil {
+		http.Error(w, "Error generating token", http.StatusInternalServerError)
+		return
+	}
 
 	// Clear password hash before sending
 	user.PasswordHash = ""
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(AuthResponse{
-		Token: token,
-		User:  user,
+		Token:        token,
+		ExpiresAt:    time.Now().Add(h.accessTTL),
+		RefreshToken: refreshToken,
+		User:         user,
 	})
 }
+
+// Logout revokes the caller's session, invalidating its access and
+// refresh tokens
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.sessionRepo.Revoke(claims.SessionID); err != nil {
+		http.Error(w, "Error logging out: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.hub.CloseSession(claims.UserID, claims.SessionID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
+}
+
+// ListSessions returns the caller's active sessions, flagging the one
+// making the request
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.sessionRepo.GetActiveByUserID(claims.UserID)
+	if err != nil {
+		http.Error(w, "Error fetching sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if sessions == nil {
+		sessions = []*models.Session{}
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == claims.SessionID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// TerminateSession revokes one of the caller's sessions and disconnects
+// its live WebSocket connections
+func (h *AuthHandler) TerminateSession(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	sessionID, err := uuid.Parse(vars["sessionId"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.sessionRepo.RevokeForUser(sessionID, claims.UserID); err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error terminating session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.CloseSession(claims.UserID, sessionID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Session terminated successfully"})
+}
+
+// ParseTrustedProxies parses proxy addresses given as IPs or CIDRs
+func ParseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	proxies := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// clientIP returns the address the request came from. Forwarding headers
+// are believed only when the request arrives from a trusted proxy, since
+// anyone else can send them. X-Forwarded-For is read from the right, past
+// any further trusted proxies: entries left of those are whatever the
+// client sent.
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !trustedProxy(remote, proxies) {
+		return remote
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			if i == 0 || !trustedProxy(hop, proxies) {
+				return hop
+			}
+		}
+		return remote
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return remote
+}
+
+func trustedProxy(addr string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		realIP    string
+		want      string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "", "203.0.113.7"},
+		{"spoofed forwarded for", "203.0.113.7:5000", []string{"1.2.3.4"}, "", "203.0.113.7"},
+		{"spoofed real ip", "203.0.113.7:5000", nil, "1.2.3.4", "203.0.113.7"},
+		{"through proxy", "10.1.2.3:5000", []string{"198.51.100.9"}, "", "198.51.100.9"},
+		{"proxy chain", "10.1.2.3:5000", []string{"198.51.100.9, 192.168.1.1"}, "", "198.51.100.9"},
+		{"client prepends a fake hop", "10.1.2.3:5000", []string{"1.2.3.4, 198.51.100.9"}, "", "198.51.100.9"},
+		{"split across headers", "10.1.2.3:5000", []string{"1.2.3.4", "198.51.100.9"}, "", "198.51.100.9"},
+		{"only proxies", "10.1.2.3:5000", []string{"10.9.9.9, 192.168.1.1"}, "", "10.9.9.9"},
+		{"garbage hop", "10.1.2.3:5000", []string{"not-an-ip"}, "", "10.1.2.3"},
+		{"real ip through proxy", "192.168.1.1:5000", nil, "198.51.100.9", "198.51.100.9"},
+		{"ipv6 proxy", "[fd00::1]:5000", []string{"2001:db8::9"}, "", "2001:db8::9"},
+		{"untrusted neighbour", "192.168.1.2:5000", []string{"198.51.100.9"}, "", "192.168.1.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/auth/login", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := clientIP(r, proxies); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("accepted an invalid CIDR")
+	}
+	if _, err := ParseTrustedProxies([]string{"proxy.internal"}); err == nil {
+		t.Error("accepted a hostname")
+	}
+}
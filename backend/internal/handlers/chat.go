@@ -2,34 +2,49 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/halizadz/chat-app-backend/internal/middleware"
 	"github.com/halizadz/chat-app-backend/internal/models"
 	"github.com/halizadz/chat-app-backend/internal/repository"
+	ws "github.com/halizadz/chat-app-backend/internal/websocket"
 )
 
 type ChatHandler struct {
-	roomRepo    *repository.RoomRepository
-	messageRepo *repository.MessageRepository
-	userRepo    *repository.UserRepository
+	roomRepo       *repository.RoomRepository
+	messageRepo    *repository.MessageRepository
+	userRepo       *repository.UserRepository
+	invitationRepo *repository.InvitationRepository
+	attachmentRepo *repository.AttachmentRepository
+	hub            *ws.Hub
+	roomQuota      int64 // Default storage quota of each room
+	previewer      *LinkPreviewer
 }
 
-func NewChatHandler(roomRepo *repository.RoomRepository, messageRepo *repository.MessageRepository, userRepo *repository.UserRepository) *ChatHandler {
+func NewChatHandler(roomRepo *repository.RoomRepository, messageRepo *repository.MessageRepository, userRepo *repository.UserRepository, invitationRepo *repository.InvitationRepository, attachmentRepo *repository.AttachmentRepository, hub *ws.Hub, roomQuota int64, previewer *LinkPreviewer) *ChatHandler {
 	return &ChatHandler{
-		roomRepo:    roomRepo,
-		messageRepo: messageRepo,
-		userRepo:    userRepo,
+		roomRepo:       roomRepo,
+		messageRepo:    messageRepo,
+		userRepo:       userRepo,
+		invitationRepo: invitationRepo,
+		attachmentRepo: attachmentRepo,
+		hub:            hub,
+		roomQuota:      roomQuota,
+		previewer:      previewer,
 	}
 }
 
 type CreateRoomRequest struct {
 	Name        string  `json:"name"`
 	Description *string `json:"description"`
-	Type        string  `json:"type"` // private or group
+	Type        string  `json:"type"`       // private or group
+	Visibility  string  `json:"visibility"` // public or private (default); groups only
 }
 
 type CreatePrivateRoomRequest struct {
@@ -37,8 +52,93 @@ type CreatePrivateRoomRequest struct {
 }
 
 type SendMessageRequest struct {
-	Content string `json:"content"`
-	Type    string `json:"type"` // text, file, image
+	Content   string     `json:"content"`
+	Type      string     `json:"type"` // text, file, image
+	FileURL   string     `json:"file_url"`
+	FileName  string     `json:"file_name"`
+	FileSize  int64      `json:"file_size"`
+	ReplyToID *uuid.UUID `json:"reply_to_id"`
+}
+
+// MessagePage is a page of messages plus the cursor that continues it
+type MessagePage struct {
+	Messages   []*models.Message `json:"messages"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	HasMore    bool              `json:"has_more"`
+}
+
+// validateVisibility checks a room visibility; only groups can be public
+func validateVisibility(roomType, visibility string) error {
+	if visibility != "public" && visibility != "private" {
+		return errors.New("Visibility must be 'public' or 'private'")
+	}
+	if visibility == "public" && roomType != "group" {
+		return errors.New("Only group rooms can be public")
+	}
+	return nil
+}
+
+// parsePageQuery reads the limit, before and after query parameters
+func parsePageQuery(r *http.Request) (repository.PageQuery, error) {
+	page := repository.PageQuery{Limit: 50}
+
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			page.Limit = l
+		}
+	}
+	if page.Limit > 100 {
+		page.Limit = 100
+	}
+
+	before := r.URL.Query().Get("before")
+	after := r.URL.Query().Get("after")
+	if before != "" && after != "" {
+		return page, errors.New("Use either before or after, not both")
+	}
+
+	var err error
+	if before != "" {
+		if page.Before, err = repository.DecodeMessageCursor(before); err != nil {
+			return page, errors.New("Invalid before cursor")
+		}
+	}
+	if after != "" {
+		if page.After, err = repository.DecodeMessageCursor(after); err != nil {
+			return page, errors.New("Invalid after cursor")
+		}
+	}
+
+	return page, nil
+}
+
+// newMessagePage builds a response whose next_cursor keeps paging in the
+// same direction: towards older messages by default, newer ones with after
+func newMessagePage(messages []*models.Message, hasMore bool, page repository.PageQuery) MessagePage {
+	result := MessagePage{Messages: messages, HasMore: hasMore}
+	if result.Messages == nil {
+		result.Messages = []*models.Message{}
+	}
+
+	var edge *models.Message
+	for _, msg := range messages {
+		if edge == nil {
+			edge = msg
+			continue
+		}
+		newer := msg.CreatedAt.After(edge.CreatedAt) ||
+			(msg.CreatedAt.Equal(edge.CreatedAt) && msg.ID.String() > edge.ID.String())
+		if newer == (page.After != nil) {
+			edge = msg
+		}
+	}
+
+	if edge != nil {
+		cursor := repository.MessageCursor{CreatedAt: edge.CreatedAt, ID: edge.ID}
+		result.NextCursor = cursor.Encode()
+	}
+
+	return result
 }
 
 func (h *ChatHandler) CreateRoom(w http.ResponseWriter, r *http.Request) {
@@ -64,10 +164,19 @@ func (h *ChatHandler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Visibility == "" {
+		req.Visibility = "private"
+	}
+	if err := validateVisibility(req.Type, req.Visibility); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	room := &models.Room{
 		Name:        req.Name,
 		Description: req.Description,
 		Type:        req.Type,
+		Visibility:  req.Visibility,
 		CreatedBy:   claims.UserID,
 	}
 
@@ -76,8 +185,8 @@ func (h *ChatHandler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add creator as admin
-	if err := h.roomRepo.AddMember(room.ID, claims.UserID, "admin"); err != nil {
+	// Add creator as owner
+	if err := h.roomRepo.AddMember(room.ID, claims.UserID, models.RoleOwner); err != nil {
 		http.Error(w, "Error adding member: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -147,15 +256,7 @@ func (h *ChatHandler) GetRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is member
-	isMember, err := h.roomRepo.IsMember(roomID, claims.UserID)
-	if err != nil {
-		http.Error(w, "Error checking membership", http.StatusInternalServerError)
-		return
-	}
-
-	if !isMember {
-		http.Error(w, "Not a member of this room", http.StatusForbidden)
+	if _, ok := h.authorize(w, roomID, claims.UserID, permParticipate); !ok {
 		return
 	}
 
@@ -183,19 +284,134 @@ func (h *ChatHandler) GetRoomMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is member
-	isMember, err := h.roomRepo.IsMember(roomID, claims.UserID)
+	if _, ok := h.authorize(w, roomID, claims.UserID, permParticipate); !ok {
+		return
+	}
+
+	page, err := parsePageQuery(r)
 	if err != nil {
-		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	messages, hasMore, err := h.messageRepo.GetByRoomID(roomID, claims.UserID, page)
+	if err != nil {
+		http.Error(w, "Error fetching messages: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newMessagePage(messages, hasMore, page))
+}
+
+// SendMessage posts a message to a room over REST and broadcasts it
+func (h *ChatHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorize(w, roomID, claims.UserID, permParticipate); !ok {
+		return
+	}
+
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// REST uses the stored type names; the WebSocket calls text "message"
+	msgType := req.Type
+	switch msgType {
+	case "", "text":
+		msgType = "message"
+	case "file", "image":
+	default:
+		http.Error(w, "Type must be 'text', 'file' or 'image'", http.StatusBadRequest)
+		return
+	}
+
+	msg := &ws.Message{
+		Type:      msgType,
+		RoomID:    roomID,
+		SenderID:  claims.UserID,
+		Username:  claims.Username,
+		Content:   req.Content,
+		FileURL:   req.FileURL,
+		FileName:  req.FileName,
+		FileSize:  req.FileSize,
+		ReplyToID: req.ReplyToID,
+	}
+
+	created, err := persistMessage(h.roomRepo, h.messageRepo, h.attachmentRepo, h.hub, h.previewer, h.roomQuota, msg)
+	if err != nil {
+		if errors.Is(err, errInvalidMessage) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errNotMember) {
+			http.Error(w, "Not a member of this room", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Error sending message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	message, err := h.messageRepo.FindByID(created.ID, claims.UserID)
+	if err != nil {
+		http.Error(w, "Error fetching message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+// GetThread returns a thread's root message and a page of its replies
+func (h *ChatHandler) GetThread(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	if !isMember {
-		http.Error(w, "Not a member of this room", http.StatusForbidden)
+	vars := mux.Vars(r)
+	messageID, err := uuid.Parse(vars["messageId"])
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	message, err := h.messageRepo.FindByID(messageID, claims.UserID)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
 		return
 	}
 
-	// Get pagination params
+	if _, ok := h.authorize(w, message.RoomID, claims.UserID, permParticipate); !ok {
+		return
+	}
+
+	// Asking for any reply returns the whole thread it belongs to
+	root := message
+	if message.ThreadRootID != nil {
+		root, err = h.messageRepo.FindByID(*message.ThreadRootID, claims.UserID)
+		if err != nil {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+	}
+
 	limitStr := r.URL.Query().Get("limit")
 	offsetStr := r.URL.Query().Get("offset")
 
@@ -203,25 +419,34 @@ func (h *ChatHandler) GetRoomMessages(w http.ResponseWriter, r *http.Request) {
 	offset := 0
 
 	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil {
-			limit = l
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 || l > 100 {
+			http.Error(w, "Limit must be between 1 and 100", http.StatusBadRequest)
+			return
 		}
+		limit = l
 	}
 
 	if offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil {
-			offset = o
+		o, err := strconv.Atoi(offsetStr)
+		if err != nil || o < 0 {
+			http.Error(w, "Offset must be a non-negative integer", http.StatusBadRequest)
+			return
 		}
+		offset = o
 	}
 
-	messages, err := h.messageRepo.GetByRoomID(roomID, limit, offset)
+	replies, err := h.messageRepo.GetThread(root.ID, claims.UserID, limit, offset)
 	if err != nil {
-		http.Error(w, "Error fetching messages: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error fetching thread: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"root":    root,
+		"replies": replies,
+	})
 }
 
 // MarkRoomAsRead marks all messages in a room as read
@@ -239,20 +464,21 @@ func (h *ChatHandler) MarkRoomAsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is member
-	isMember, err := h.roomRepo.IsMember(roomID, claims.UserID)
-	if err != nil {
-		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+	if _, ok := h.authorize(w, roomID, claims.UserID, permParticipate); !ok {
 		return
 	}
 
-	if !isMember {
-		http.Error(w, "Not a member of this room", http.StatusForbidden)
-		return
+	// Mark all messages as read, or only up to a seq if the client says so
+	var upToSeq int64
+	if seqStr := r.URL.Query().Get("seq"); seqStr != "" {
+		upToSeq, err = strconv.ParseInt(seqStr, 10, 64)
+		if err != nil || upToSeq < 0 {
+			http.Error(w, "Invalid seq", http.StatusBadRequest)
+			return
+		}
 	}
 
-	// Mark all messages as read
-	if err := h.messageRepo.MarkRoomMessagesAsRead(roomID, claims.UserID); err != nil {
+	if err := markRead(h.messageRepo, h.hub, roomID, claims.UserID, claims.Username, upToSeq); err != nil {
 		http.Error(w, "Error marking messages as read: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -276,50 +502,240 @@ func (h *ChatHandler) SearchMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is member
-	isMember, err := h.roomRepo.IsMember(roomID, claims.UserID)
+	if _, ok := h.authorize(w, roomID, claims.UserID, permParticipate); !ok {
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "Search query is required", http.StatusBadRequest)
+		return
+	}
+
+	page, err := parsePageQuery(r)
 	if err != nil {
-		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if !isMember {
-		http.Error(w, "Not a member of this room", http.StatusForbidden)
+	messages, hasMore, err := h.messageRepo.SearchMessages(roomID, query, page)
+	if err != nil {
+		http.Error(w, "Error searching messages: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	query := r.URL.Query().Get("q")
-	if query == "" {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newMessagePage(messages, hasMore, page))
+}
+
+// FilePage is a page of a room's file gallery
+type FilePage struct {
+	Files      []*models.RoomFile `json:"files"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+	HasMore    bool               `json:"has_more"`
+}
+
+// GetRoomFiles lists the files sent in a room, newest first. It can be
+// narrowed by category, sender and date, and pages like the room history.
+func (h *ChatHandler) GetRoomFiles(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorize(w, roomID, claims.UserID, permParticipate); !ok {
+		return
+	}
+
+	page, err := parsePageQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params := r.URL.Query()
+	var filter repository.FileFilter
+
+	switch filter.Category = params.Get("type"); filter.Category {
+	case "", repository.FileCategoryImage, repository.FileCategoryDocument, repository.FileCategoryOther:
+	default:
+		http.Error(w, "Type must be 'image', 'document' or 'other'", http.StatusBadRequest)
+		return
+	}
+
+	if senderIDStr := params.Get("sender_id"); senderIDStr != "" {
+		senderID, err := uuid.Parse(senderIDStr)
+		if err != nil {
+			http.Error(w, "Invalid sender ID", http.StatusBadRequest)
+			return
+		}
+		filter.SenderID = &senderID
+	}
+
+	if filter.From, err = parseSearchTime(params.Get("from"), false); err != nil {
+		http.Error(w, "Invalid from date", http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseSearchTime(params.Get("to"), true); err != nil {
+		http.Error(w, "Invalid to date", http.StatusBadRequest)
+		return
+	}
+
+	files, hasMore, err := h.messageRepo.GetRoomFiles(roomID, filter, page)
+	if err != nil {
+		http.Error(w, "Error fetching files: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := FilePage{Files: files, HasMore: hasMore}
+	if result.Files == nil {
+		result.Files = []*models.RoomFile{}
+	}
+
+	// Keep paging the same way: older by default, newer with after
+	if len(files) > 0 {
+		edge := files[len(files)-1]
+		if page.After != nil {
+			edge = files[0]
+		}
+		cursor := repository.MessageCursor{CreatedAt: edge.CreatedAt, ID: edge.MessageID}
+		result.NextCursor = cursor.Encode()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// SearchResultPage is a page of full-text search results
+type SearchResultPage struct {
+	Results    []*models.SearchResult `json:"results"`
+	HasMore    bool                   `json:"has_more"`
+	NextOffset int                    `json:"next_offset,omitempty"`
+}
+
+// parseSearchTime reads an RFC 3339 timestamp or a YYYY-MM-DD date. A
+// date given as the end of a range includes that whole day.
+func parseSearchTime(value string, end bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	// Timestamps are stored as server-local wall-clock times
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		t = t.In(time.Local)
+		return &t, nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// SearchAllMessages runs a ranked full-text search across every room the
+// caller belongs to
+func (h *ChatHandler) SearchAllMessages(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+
+	filter := repository.SearchFilter{
+		Query: strings.TrimSpace(params.Get("q")),
+		Limit: 20,
+	}
+	if filter.Query == "" {
 		http.Error(w, "Search query is required", http.StatusBadRequest)
 		return
 	}
 
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
+	if limitStr := params.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 100 {
+			http.Error(w, "Limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
 
-	limit := 50
-	offset := 0
+	if offsetStr := params.Get("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		filter.Offset = offset
+	}
 
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil {
-			limit = l
+	if roomIDStr := params.Get("room_id"); roomIDStr != "" {
+		roomID, err := uuid.Parse(roomIDStr)
+		if err != nil {
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
 		}
+		filter.RoomID = &roomID
 	}
 
-	if offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil {
-			offset = o
+	if senderIDStr := params.Get("sender_id"); senderIDStr != "" {
+		senderID, err := uuid.Parse(senderIDStr)
+		if err != nil {
+			http.Error(w, "Invalid sender ID", http.StatusBadRequest)
+			return
 		}
+		filter.SenderID = &senderID
 	}
 
-	messages, err := h.messageRepo.SearchMessages(roomID, query, limit, offset)
+	var err error
+	if filter.From, err = parseSearchTime(params.Get("from"), false); err != nil {
+		http.Error(w, "Invalid from date", http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseSearchTime(params.Get("to"), true); err != nil {
+		http.Error(w, "Invalid to date", http.StatusBadRequest)
+		return
+	}
+
+	switch filter.Type = params.Get("type"); filter.Type {
+	case "", "text", "file", "image":
+	default:
+		http.Error(w, "Type must be 'text', 'file' or 'image'", http.StatusBadRequest)
+		return
+	}
+
+	results, hasMore, err := h.messageRepo.SearchAll(claims.UserID, filter)
 	if err != nil {
 		http.Error(w, "Error searching messages: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	page := SearchResultPage{
+		Results: results,
+		HasMore: hasMore,
+	}
+	if page.Results == nil {
+		page.Results = []*models.SearchResult{}
+	}
+	if hasMore {
+		page.NextOffset = filter.Offset + len(results)
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(page)
 }
 
 // UpdateMessage updates a message
@@ -338,12 +754,16 @@ func (h *ChatHandler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get message
-	message, err := h.messageRepo.FindByID(messageID)
+	message, err := h.messageRepo.FindByID(messageID, claims.UserID)
 	if err != nil {
 		http.Error(w, "Message not found", http.StatusNotFound)
 		return
 	}
 
+	if _, ok := h.authorize(w, message.RoomID, claims.UserID, permParticipate); !ok {
+		return
+	}
+
 	// Check if user is the sender
 	if message.SenderID != claims.UserID {
 		http.Error(w, "You can only edit your own messages", http.StatusForbidden)
@@ -371,7 +791,26 @@ func (h *ChatHandler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get updated message
-	updatedMessage, _ := h.messageRepo.FindByID(messageID)
+	updatedMessage, err := h.messageRepo.FindByID(messageID, claims.UserID)
+	if err != nil {
+		http.Error(w, "Error fetching message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Let everyone in the room see the edit live
+	h.hub.BroadcastToRoom(updatedMessage.RoomID, ws.MessageEvent{
+		Type:        "message_edited",
+		RoomID:      updatedMessage.RoomID,
+		MessageID:   updatedMessage.ID,
+		Content:     updatedMessage.Content,
+		LinkPreview: updatedMessage.LinkPreview,
+		UpdatedAt:   updatedMessage.UpdatedAt,
+	})
+
+	// Editing drops the old preview; the new content may link elsewhere
+	if h.previewer != nil && updatedMessage.LinkPreview == nil {
+		h.previewer.Enqueue(updatedMessage)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedMessage)
@@ -393,35 +832,33 @@ func (h *ChatHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get message
-	message, err := h.messageRepo.FindByID(messageID)
+	message, err := h.messageRepo.FindByID(messageID, claims.UserID)
 	if err != nil {
 		http.Error(w, "Message not found", http.StatusNotFound)
 		return
 	}
 
-	// Check if user is the sender or admin
-	isSender := message.SenderID == claims.UserID
-	isAdmin := false
-
-	if !isSender {
-		// Check if user is admin of the room
-		members, err := h.roomRepo.GetMembers(message.RoomID)
-		if err == nil {
-			for _, member := range members {
-				if member.ID == claims.UserID {
-					// Check role in room_members
-					// For simplicity, we'll check if user is creator
-					room, _ := h.roomRepo.FindByID(message.RoomID)
-					isAdmin = room.CreatedBy == claims.UserID
-					break
-				}
-			}
+	// Members delete their own messages; moderators and above delete
+	// messages from members who rank below them
+	if message.SenderID == claims.UserID {
+		if _, ok := h.authorize(w, message.RoomID, claims.UserID, permParticipate); !ok {
+			return
+		}
+	} else {
+		role, ok := h.authorize(w, message.RoomID, claims.UserID, permModerateMessages)
+		if !ok {
+			return
 		}
-	}
 
-	if !isSender && !isAdmin {
-		http.Error(w, "You can only delete your own messages", http.StatusForbidden)
-		return
+		senderRole, err := h.roomRepo.GetMemberRole(message.RoomID, message.SenderID)
+		if err != nil {
+			http.Error(w, "Error checking membership", http.StatusInternalServerError)
+			return
+		}
+		if !outranks(role, senderRole) {
+			http.Error(w, "You can only delete messages from members below your role", http.StatusForbidden)
+			return
+		}
 	}
 
 	// Delete message
@@ -430,6 +867,17 @@ func (h *ChatHandler) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Let everyone in the room see the deletion live
+	if deleted, err := h.messageRepo.FindByID(messageID, claims.UserID); err == nil {
+		h.hub.BroadcastToRoom(deleted.RoomID, ws.MessageEvent{
+			Type:      "message_deleted",
+			RoomID:    deleted.RoomID,
+			MessageID: deleted.ID,
+			Content:   deleted.Content,
+			UpdatedAt: deleted.UpdatedAt,
+		})
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Message deleted successfully"})
 }
@@ -448,15 +896,7 @@ func (h *ChatHandler) GetRoomMembers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is member
-	isMember, err := h.roomRepo.IsMember(roomID, claims.UserID)
-	if err != nil {
-		http.Error(w, "Error checking membership", http.StatusInternalServerError)
-		return
-	}
-
-	if !isMember {
-		http.Error(w, "Not a member of this room", http.StatusForbidden)
+	if _, ok := h.authorize(w, roomID, claims.UserID, permParticipate); !ok {
 		return
 	}
 
@@ -470,6 +910,8 @@ func (h *ChatHandler) GetRoomMembers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(members)
 }
 
+// AddRoomMember invites a user to a group room, or adds them straight to
+// a private room that has a free seat
 func (h *ChatHandler) AddRoomMember(w http.ResponseWriter, r *http.Request) {
 	claims, ok := middleware.GetUserFromContext(r.Context())
 	if !ok {
@@ -493,15 +935,7 @@ func (h *ChatHandler) AddRoomMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if requester is member
-	isMember, err := h.roomRepo.IsMember(roomID, claims.UserID)
-	if err != nil {
-		http.Error(w, "Error checking membership", http.StatusInternalServerError)
-		return
-	}
-
-	if !isMember {
-		http.Error(w, "Not a member of this room", http.StatusForbidden)
+	if _, ok := h.authorize(w, roomID, claims.UserID, permAddMembers); !ok {
 		return
 	}
 
@@ -529,20 +963,24 @@ func (h *ChatHandler) AddRoomMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if room.Type == "private" {
-		members, err := h.roomRepo.GetMembers(roomID)
-		if err != nil {
-			http.Error(w, "Error fetching members", http.StatusInternalServerError)
-			return
-		}
-		if len(members) >= 2 {
-			http.Error(w, "Private room can only have 2 members", http.StatusBadRequest)
-			return
-		}
+	// Group members join by accepting an invitation
+	if room.Type == "group" {
+		h.inviteMember(w, claims, room, req.UserID)
+		return
+	}
+
+	members, err := h.roomRepo.GetMembers(roomID)
+	if err != nil {
+		http.Error(w, "Error fetching members", http.StatusInternalServerError)
+		return
+	}
+	if len(members) >= 2 {
+		http.Error(w, "Private room can only have 2 members", http.StatusBadRequest)
+		return
 	}
 
 	// Add new member
-	if err := h.roomRepo.AddMember(roomID, req.UserID, "member"); err != nil {
+	if err := h.roomRepo.AddMember(roomID, req.UserID, models.RoleMember); err != nil {
 		http.Error(w, "Error adding member: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -565,11 +1003,28 @@ func (h *ChatHandler) LeaveRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.roomRepo.RemoveMember(roomID, claims.UserID); err != nil {
+	if _, ok := h.authorize(w, roomID, claims.UserID, permParticipate); !ok {
+		return
+	}
+
+	// Owners hand the room to someone else on the way out
+	newOwnerID, err := h.roomRepo.LeaveRoom(roomID, claims.UserID)
+	if err != nil {
 		http.Error(w, "Error leaving room: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	h.hub.RemoveFromRoom(roomID, claims.UserID)
+
+	if newOwnerID != uuid.Nil {
+		h.hub.BroadcastToRoom(roomID, ws.RoleEvent{
+			Type:   "member_role",
+			RoomID: roomID,
+			UserID: newOwnerID,
+			Role:   models.RoleOwner,
+		})
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Left room successfully"})
 }
@@ -589,6 +1044,10 @@ func (h *ChatHandler) UpdateRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, ok := h.authorize(w, roomID, claims.UserID, permUpdateRoom); !ok {
+		return
+	}
+
 	// Get room
 	room, err := h.roomRepo.FindByID(roomID)
 	if err != nil {
@@ -596,15 +1055,10 @@ func (h *ChatHandler) UpdateRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user is creator or admin
-	if room.CreatedBy != claims.UserID {
-		http.Error(w, "Only room creator can update room", http.StatusForbidden)
-		return
-	}
-
 	var req struct {
 		Name        *string `json:"name"`
 		Description *string `json:"description"`
+		Visibility  *string `json:"visibility"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -618,6 +1072,13 @@ func (h *ChatHandler) UpdateRoom(w http.ResponseWriter, r *http.Request) {
 	if req.Description != nil {
 		room.Description = req.Description
 	}
+	if req.Visibility != nil {
+		if err := validateVisibility(room.Type, *req.Visibility); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		room.Visibility = *req.Visibility
+	}
 
 	if err := h.roomRepo.Update(room); err != nil {
 		http.Error(w, "Error updating room: "+err.Error(), http.StatusInternalServerError)
@@ -643,16 +1104,7 @@ func (h *ChatHandler) DeleteRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get room
-	room, err := h.roomRepo.FindByID(roomID)
-	if err != nil {
-		http.Error(w, "Room not found", http.StatusNotFound)
-		return
-	}
-
-	// Check if user is creator
-	if room.CreatedBy != claims.UserID {
-		http.Error(w, "Only room creator can delete room", http.StatusForbidden)
+	if _, ok := h.authorize(w, roomID, claims.UserID, permOwnRoom); !ok {
 		return
 	}
 
@@ -661,6 +1113,9 @@ func (h *ChatHandler) DeleteRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Nobody belongs to the room anymore
+	h.hub.RemoveFromRoom(roomID)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Room deleted successfully"})
 }
@@ -686,22 +1141,29 @@ func (h *ChatHandler) RemoveRoomMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if requester is creator or admin
-	room, err := h.roomRepo.FindByID(roomID)
-	if err != nil {
-		http.Error(w, "Room not found", http.StatusNotFound)
+	if userID == claims.UserID {
+		http.Error(w, "Use leave to remove yourself", http.StatusBadRequest)
 		return
 	}
 
-	isCreator := room.CreatedBy == claims.UserID
-	if !isCreator {
-		http.Error(w, "Only room creator can remove members", http.StatusForbidden)
+	role, ok := h.authorize(w, roomID, claims.UserID, permRemoveMembers)
+	if !ok {
+		return
+	}
+
+	targetRole, err := h.roomRepo.GetMemberRole(roomID, userID)
+	if err != nil {
+		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		return
+	}
+	if targetRole == "" {
+		http.Error(w, "User is not a member of this room", http.StatusNotFound)
 		return
 	}
 
-	// Cannot remove creator
-	if userID == room.CreatedBy {
-		http.Error(w, "Cannot remove room creator", http.StatusBadRequest)
+	// The owner can never be removed, since nobody outranks them
+	if !outranks(role, targetRole) {
+		http.Error(w, "You can only remove members below your role", http.StatusForbidden)
 		return
 	}
 
@@ -710,6 +1172,222 @@ func (h *ChatHandler) RemoveRoomMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.hub.RemoveFromRoom(roomID, userID)
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Member removed successfully"})
 }
+
+// UpdateMemberRole changes a member's role. Admins and above may assign
+// roles below their own to members below them; the owner may also hand
+// ownership to another member, becoming an admin.
+func (h *ChatHandler) UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := uuid.Parse(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if models.RoleRank(req.Role) == 0 {
+		http.Error(w, "Role must be 'owner', 'admin', 'moderator' or 'member'", http.StatusBadRequest)
+		return
+	}
+
+	if userID == claims.UserID {
+		http.Error(w, "Cannot change your own role", http.StatusBadRequest)
+		return
+	}
+
+	role, ok := h.authorize(w, roomID, claims.UserID, permManageRoles)
+	if !ok {
+		return
+	}
+
+	room, err := h.roomRepo.FindByID(roomID)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	if room.Type == "private" {
+		http.Error(w, "Private rooms do not have roles", http.StatusBadRequest)
+		return
+	}
+
+	targetRole, err := h.roomRepo.GetMemberRole(roomID, userID)
+	if err != nil {
+		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		return
+	}
+	if targetRole == "" {
+		http.Error(w, "User is not a member of this room", http.StatusNotFound)
+		return
+	}
+
+	if req.Role == models.RoleOwner {
+		if role != models.RoleOwner {
+			http.Error(w, "Only the room owner can transfer ownership", http.StatusForbidden)
+			return
+		}
+
+		err := h.roomRepo.TransferOwnership(roomID, claims.UserID, userID)
+		if errors.Is(err, repository.ErrNotMember) {
+			http.Error(w, "User is not a member of this room", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrRoleChanged) {
+			http.Error(w, "Only the room owner can transfer ownership", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Error transferring ownership: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.hub.BroadcastToRoom(roomID, ws.RoleEvent{
+			Type:   "member_role",
+			RoomID: roomID,
+			UserID: claims.UserID,
+			Role:   models.RoleAdmin,
+		})
+	} else {
+		if !outranks(role, targetRole) || !outranks(role, req.Role) {
+			http.Error(w, "You can only assign roles below your own to members below you", http.StatusForbidden)
+			return
+		}
+
+		if err := h.roomRepo.UpdateMemberRole(roomID, userID, req.Role); err != nil {
+			http.Error(w, "Error updating role: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.hub.BroadcastToRoom(roomID, ws.RoleEvent{
+		Type:   "member_role",
+		RoomID: roomID,
+		UserID: userID,
+		Role:   req.Role,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Role updated successfully"})
+}
+
+// AddReaction reacts to a message with an emoji
+func (h *ChatHandler) AddReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, "added")
+}
+
+// RemoveReaction withdraws the caller's emoji reaction from a message
+func (h *ChatHandler) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	h.changeReaction(w, r, "removed")
+}
+
+func (h *ChatHandler) changeReaction(w http.ResponseWriter, r *http.Request, action string) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	messageID, err := uuid.Parse(vars["messageId"])
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	// DELETE may carry the emoji as a query parameter instead of a body
+	emoji := r.URL.Query().Get("emoji")
+	if emoji == "" {
+		var req struct {
+			Emoji string `json:"emoji"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		emoji = req.Emoji
+	}
+
+	emoji = strings.TrimSpace(emoji)
+	if emoji == "" || len(emoji) > 32 {
+		http.Error(w, "Emoji must be between 1 and 32 bytes", http.StatusBadRequest)
+		return
+	}
+
+	message, err := h.messageRepo.FindByID(messageID, claims.UserID)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	if _, ok := h.authorize(w, message.RoomID, claims.UserID, permParticipate); !ok {
+		return
+	}
+
+	var changed bool
+	if action == "added" {
+		if message.IsDeleted {
+			http.Error(w, "Cannot react to a deleted message", http.StatusBadRequest)
+			return
+		}
+		changed, err = h.messageRepo.AddReaction(messageID, claims.UserID, emoji)
+	} else {
+		changed, err = h.messageRepo.RemoveReaction(messageID, claims.UserID, emoji)
+	}
+	if err != nil {
+		http.Error(w, "Error updating reaction: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if changed {
+		count, err := h.messageRepo.CountReactions(messageID, emoji)
+		if err != nil {
+			http.Error(w, "Error counting reactions: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.hub.BroadcastToRoom(message.RoomID, ws.ReactionEvent{
+			Type:      "reaction",
+			RoomID:    message.RoomID,
+			MessageID: messageID,
+			UserID:    claims.UserID,
+			Username:  claims.Username,
+			Emoji:     emoji,
+			Action:    action,
+			Count:     count,
+		})
+	}
+
+	updated, err := h.messageRepo.FindByID(messageID, claims.UserID)
+	if err != nil {
+		http.Error(w, "Error fetching message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
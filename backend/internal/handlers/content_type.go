@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLength is how much of a file sniffContentType looks at
+const sniffLength = 512
+
+// oleSignature starts legacy Office documents (.doc, .xls, .ppt), which
+// http.DetectContentType doesn't know
+var oleSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// Formats that sniff as a generic container. The extension picks the
+// specific type, but only when the content really is that container.
+var containerTypes = map[string]struct{ container, contentType string }{
+	".docx": {"application/zip", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	".xlsx": {"application/zip", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	".pptx": {"application/zip", "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	".odt":  {"application/zip", "application/vnd.oasis.opendocument.text"},
+	".ods":  {"application/zip", "application/vnd.oasis.opendocument.spreadsheet"},
+	".odp":  {"application/zip", "application/vnd.oasis.opendocument.presentation"},
+	".epub": {"application/zip", "application/epub+zip"},
+	".doc":  {"application/x-ole-storage", "application/msword"},
+	".xls":  {"application/x-ole-storage", "application/vnd.ms-excel"},
+	".ppt":  {"application/x-ole-storage", "application/vnd.ms-powerpoint"},
+	".csv":  {"text/plain", "text/csv"},
+	".md":   {"text/plain", "text/markdown"},
+	".json": {"text/plain", "application/json"},
+}
+
+// sniffContentType decides a file's type from its first bytes. The name
+// only narrows down a container format the content already matches, so
+// renaming a file can't change what it is served as.
+func sniffContentType(head []byte, fileName string) string {
+	contentType := http.DetectContentType(head)
+	if bytes.HasPrefix(head, oleSignature) {
+		contentType = "application/x-ole-storage"
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+
+	refined, ok := containerTypes[strings.ToLower(filepath.Ext(fileName))]
+	if !ok || refined.container != mediaType {
+		return contentType
+	}
+	if charset := params["charset"]; charset != "" {
+		return mime.FormatMediaType(refined.contentType, map[string]string{"charset": charset})
+	}
+	return refined.contentType
+}
+
+// allowedContentType reports whether contentType matches an entry of
+// allowed: an exact media type, a family like image/*, or */*
+func allowedContentType(allowed []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if entry == "*/*" || entry == mediaType {
+			return true
+		}
+		if family, ok := strings.CutSuffix(entry, "/*"); ok && strings.HasPrefix(mediaType, family+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/halizadz/chat-app-backend/internal/middleware"
+	"github.com/halizadz/chat-app-backend/internal/repository"
+)
+
+type BanRequest struct {
+	UserID uuid.UUID `json:"user_id"`
+	Reason *string   `json:"reason"`
+}
+
+// DiscoverRooms lists public group rooms, optionally filtered by a search
+// on name and description
+func (h *ChatHandler) DiscoverRooms(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+	limitStr := r.URL.Query().Get("limit")
+	offsetStr := r.URL.Query().Get("offset")
+
+	limit := 20
+	offset := 0
+
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+			limit = l
+		}
+	}
+
+	if offsetStr != "" {
+		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	rooms, err := h.roomRepo.DiscoverRooms(claims.UserID, search, limit, offset)
+	if err != nil {
+		http.Error(w, "Error fetching rooms: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rooms)
+}
+
+// JoinRoom adds the caller to a public group room
+func (h *ChatHandler) JoinRoom(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	room, err := h.roomRepo.FindByID(roomID)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	// Private rooms are indistinguishable from missing ones to outsiders
+	if room.Visibility != "public" {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := h.roomRepo.JoinRoom(roomID, claims.UserID); err != nil {
+		if errors.Is(err, repository.ErrBanned) {
+			http.Error(w, "You are banned from this room", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Error joining room: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
+
+// GetBans lists the users banned from a room
+func (h *ChatHandler) GetBans(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorize(w, roomID, claims.UserID, permBanMembers); !ok {
+		return
+	}
+
+	bans, err := h.roomRepo.GetBans(roomID)
+	if err != nil {
+		http.Error(w, "Error fetching bans: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bans)
+}
+
+// BanMember blocks a user from joining a room, removing them first if
+// they are a member
+func (h *ChatHandler) BanMember(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	var req BanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == claims.UserID {
+		http.Error(w, "Cannot ban yourself", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorize(w, roomID, claims.UserID, permBanMembers); !ok {
+		return
+	}
+
+	if _, err := h.userRepo.FindByID(req.UserID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	// Members can only be banned by someone who outranks them, which
+	// BanUser checks as it bans
+	err = h.roomRepo.BanUser(roomID, req.UserID, claims.UserID, req.Reason)
+	if errors.Is(err, repository.ErrOutranked) {
+		http.Error(w, "You can only ban members below your role", http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, repository.ErrRoleChanged) {
+		http.Error(w, "Not a member of this room", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error banning user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Banning removes the membership; drop any subscriptions it left behind
+	h.hub.RemoveFromRoom(roomID, req.UserID)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "User banned successfully"})
+}
+
+// UnbanMember lets a banned user join the room again
+func (h *ChatHandler) UnbanMember(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := uuid.Parse(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorize(w, roomID, claims.UserID, permBanMembers); !ok {
+		return
+	}
+
+	unbanned, err := h.roomRepo.UnbanUser(roomID, userID)
+	if err != nil {
+		http.Error(w, "Error unbanning user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !unbanned {
+		http.Error(w, "User is not banned from this room", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "User unbanned successfully"})
+}
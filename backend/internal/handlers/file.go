@@ -1,31 +1,114 @@
 package handlers
 
 import (
+    "bufio"
+    "bytes"
+    "context"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
+    "log"
+    "mime"
     "net/http"
-    "os"
+    "net/url"
     "path/filepath"
+    "strconv"
+    "strings"
+    "time"
 
     "github.com/google/uuid"
+    "github.com/gorilla/mux"
+    "github.com/halizadz/chat-app-backend/internal/imaging"
     "github.com/halizadz/chat-app-backend/internal/middleware"
+    "github.com/halizadz/chat-app-backend/internal/models"
+    "github.com/halizadz/chat-app-backend/internal/repository"
+    "github.com/halizadz/chat-app-backend/internal/scanner"
+    "github.com/halizadz/chat-app-backend/internal/storage"
 )
 
+// How long a signed download link works
+const signedURLTTL = 15 * time.Minute
+
+// Image types browsers can show inline without running scripts; anything
+// else, SVG included, is always downloaded
+var inlineContentTypes = map[string]bool{
+    "image/png":  true,
+    "image/jpeg": true,
+    "image/gif":  true,
+    "image/webp": true,
+}
+
+// UploadPolicy limits what users may upload
+type UploadPolicy struct {
+    // Largest file an upload may create, and how long an abandoned chunked
+    // one is kept
+    MaxUploadSize int64
+    UploadExpiry  time.Duration
+
+    // Content types uploads may have, matched by allowedContentType
+    AllowedTypes []string
+
+    // Default storage quota of each user; 0 means unlimited
+    UserQuota int64
+}
+
 type FileHandler struct {
-    uploadDir string
+    storage        storage.Storage
+    scanner        scanner.Scanner
+    attachmentRepo *repository.AttachmentRepository
+    uploadRepo     *repository.UploadRepository
+    roomRepo       *repository.RoomRepository
+    signer         *storage.Signer
+    policy         UploadPolicy
 }
 
-func NewFileHandler(uploadDir string) *FileHandler {
-    // Create upload directory if it doesn't exist
-    os.MkdirAll(uploadDir, os.ModePerm)
-    return &FileHandler{uploadDir: uploadDir}
+func NewFileHandler(store storage.Storage, fileScanner scanner.Scanner, attachmentRepo *repository.AttachmentRepository, uploadRepo *repository.UploadRepository, roomRepo *repository.RoomRepository, signer *storage.Signer, policy UploadPolicy) *FileHandler {
+    return &FileHandler{
+        storage:        store,
+        scanner:        fileScanner,
+        attachmentRepo: attachmentRepo,
+        uploadRepo:     uploadRepo,
+        roomRepo:       roomRepo,
+        signer:         signer,
+        policy:         policy,
+    }
 }
 
 type FileUploadResponse struct {
-    URL      string `json:"url"`
-    FileName string `json:"file_name"`
-    FileSize int64  `json:"file_size"`
+    ID          uuid.UUID         `json:"id"`
+    Type        string            `json:"type"` // image or file
+    URL         string            `json:"url"`
+    FileName    string            `json:"file_name"`
+    FileSize    int64             `json:"file_size"`
+    ContentType string            `json:"content_type"`
+    Width       *int              `json:"width,omitempty"`
+    Height      *int              `json:"height,omitempty"`
+    Thumbnails  models.Thumbnails `json:"thumbnails,omitempty"`
+}
+
+type SignedURLResponse struct {
+    URL       string    `json:"url"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+func attachmentIDFromURL(fileURL string) (uuid.UUID, bool) {
+    rest, ok := strings.CutPrefix(fileURL, models.AttachmentURLPrefix)
+    if !ok {
+        return uuid.Nil, false
+    }
+    id, err := uuid.Parse(rest)
+    return id, err == nil
+}
+
+// thumbnailKey is where a thumbnail of the object stored under key is kept
+func thumbnailKey(key string, size int) string {
+    return fmt.Sprintf("thumbnails/%d/%s", size, key)
+}
+
+// signedValue is what a download signature covers
+func signedValue(id uuid.UUID) string {
+    return "attachment:" + id.String()
 }
 
 func (h *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
@@ -35,10 +118,17 @@ func (h *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // Parse multipart form
-    err := r.ParseMultipartForm(10 << 20) // 10 MB max
+    // The whole request counts towards the upload limit. Up to 10 MB of
+    // the form is kept in memory and the rest spills to disk.
+    r.Body = http.MaxBytesReader(w, r.Body, h.policy.MaxUploadSize)
+    err := r.ParseMultipartForm(10 << 20)
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+        http.Error(w, fmt.Sprintf("File exceeds the %d MB upload limit", h.policy.MaxUploadSize>>20), http.StatusRequestEntityTooLarge)
+        return
+    }
     if err != nil {
-        http.Error(w, "File too large", http.StatusBadRequest)
+        http.Error(w, "Invalid multipart form", http.StatusBadRequest)
         return
     }
 
@@ -49,35 +139,531 @@ func (h *FileHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
     }
     defer file.Close()
 
+    // Fail before the file is processed and stored; the quota is checked
+    // for real when the attachment is recorded
+    usage, err := h.attachmentRepo.StorageUsage(claims.UserID, h.policy.UserQuota)
+    if err != nil {
+        http.Error(w, "Error fetching storage usage: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if usage.Remaining != nil && handler.Size > *usage.Remaining {
+        http.Error(w, "Storage quota exceeded", http.StatusRequestEntityTooLarge)
+        return
+    }
+
+    attachment, err := h.storeAttachment(r.Context(), claims.UserID, handler.Filename, file, handler.Size)
+    if err != nil {
+        writeUploadError(w, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(uploadResponse(attachment))
+}
+
+var (
+    // errInvalidUpload marks storeAttachment failures caused by the upload
+    // itself rather than the server
+    errInvalidUpload = errors.New("invalid upload")
+
+    // errUnsupportedType means the upload's content isn't an allowed type
+    errUnsupportedType = errors.New("file type is not allowed")
+
+    // errQuarantined means the malware scanner flagged the upload
+    errQuarantined = errors.New("file was flagged by the malware scanner")
+)
+
+func writeUploadError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, errInvalidUpload):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    case errors.Is(err, errUnsupportedType):
+        http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+        return
+    case errors.Is(err, repository.ErrQuotaExceeded):
+        http.Error(w, "Storage quota exceeded", http.StatusRequestEntityTooLarge)
+        return
+    case errors.Is(err, errQuarantined):
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    log.Printf("error storing upload: %v", err)
+    http.Error(w, "Error saving file", http.StatusInternalServerError)
+}
+
+// storeAttachment runs an uploaded file through the upload pipeline and
+// records it as the user's attachment. The content decides its type, which
+// must be allowed; images are stored without metadata and get thumbnails.
+// Files the scanner flags are quarantined instead. body must yield exactly
+// size bytes.
+func (h *FileHandler) storeAttachment(ctx context.Context, userID uuid.UUID, fileName string, body io.Reader, size int64) (*models.Attachment, error) {
     // Generate unique filename
-    ext := filepath.Ext(handler.Filename)
-    filename := fmt.Sprintf("%s-%s%s", claims.UserID.String(), uuid.New().String(), ext)
-    filepath := filepath.Join(h.uploadDir, filename)
+    ext := filepath.Ext(fileName)
+    filename := fmt.Sprintf("%s-%s%s", userID.String(), uuid.New().String(), ext)
+
+    // Trust the content over the name or the client's Content-Type
+    buffered := bufio.NewReaderSize(body, sniffLength)
+    head, err := buffered.Peek(sniffLength)
+    if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+        return nil, err
+    }
+    contentType := sniffContentType(head, fileName)
+    if !allowedContentType(h.policy.AllowedTypes, contentType) {
+        return nil, fmt.Errorf("%w: %s", errUnsupportedType, contentType)
+    }
+
+    attachment := &models.Attachment{
+        ID:          uuid.New(),
+        UploaderID:  userID,
+        StorageKey:  filename,
+        FileName:    fileName,
+        ContentType: contentType,
+        Size:        size,
+    }
+
+    // Objects written so far, removed again if the upload fails
+    var stored []string
+    cleanup := func() {
+        for _, key := range stored {
+            h.storage.Delete(ctx, key)
+        }
+    }
+
+    var data io.Reader = buffered
+    if imaging.Supported(contentType) {
+        if size > imaging.MaxFileSize {
+            return nil, fmt.Errorf("%w: images over %d MB can't be processed", errInvalidUpload, imaging.MaxFileSize>>20)
+        }
+
+        raw, err := io.ReadAll(buffered)
+        if err != nil {
+            return nil, err
+        }
+
+        img, err := imaging.Process(raw)
+        if errors.Is(err, imaging.ErrTooLarge) {
+            return nil, fmt.Errorf("%w: image dimensions are too large", errInvalidUpload)
+        }
+        if err != nil {
+            return nil, fmt.Errorf("%w: invalid image", errInvalidUpload)
+        }
+
+        // Stored without its EXIF and GPS metadata
+        data = bytes.NewReader(img.Data)
+        attachment.Size = int64(len(img.Data))
+        attachment.Width = &img.Width
+        attachment.Height = &img.Height
+
+        for _, side := range imaging.ThumbnailSizes {
+            thumbnail, ok, err := img.Thumbnail(side)
+            if err != nil {
+                cleanup()
+                return nil, fmt.Errorf("error creating thumbnail: %w", err)
+            }
+            if !ok {
+                continue
+            }
+
+            key := thumbnailKey(filename, side)
+            if err := h.storage.Put(ctx, key, bytes.NewReader(thumbnail.Data), int64(len(thumbnail.Data)), thumbnail.ContentType); err != nil {
+                cleanup()
+                return nil, err
+            }
+            stored = append(stored, key)
+
+            attachment.Thumbnails = append(attachment.Thumbnails, models.Thumbnail{
+                Size:   side,
+                Width:  thumbnail.Width,
+                Height: thumbnail.Height,
+                URL:    models.ThumbnailURL(attachment.ID, side),
+            })
+        }
+    }
+
+    result, err := h.putScanned(ctx, filename, data, attachment.Size, contentType)
+    if err != nil {
+        cleanup()
+        return nil, err
+    }
+
+    // The file itself is kept for review; only its thumbnails go
+    if result.Infected {
+        h.quarantine(ctx, attachment, result.Signature)
+        cleanup()
+        return nil, errQuarantined
+    }
+    stored = append(stored, filename)
+
+    if err := h.attachmentRepo.Create(attachment, h.policy.UserQuota); err != nil {
+        cleanup()
+        return nil, err
+    }
+
+    return attachment, nil
+}
+
+// putScanned stores data under key while the scanner reads the same bytes,
+// so large files aren't read twice. A file the scanner can't vouch for is
+// removed again and reported as an error.
+func (h *FileHandler) putScanned(ctx context.Context, key string, data io.Reader, size int64, contentType string) (*scanner.Result, error) {
+    type verdict struct {
+        result *scanner.Result
+        err    error
+    }
+
+    pr, pw := io.Pipe()
+    verdicts := make(chan verdict, 1)
+    go func() {
+        result, err := h.scanner.Scan(ctx, pr)
+        // Keep the pipe flowing if the scanner gave up early
+        io.Copy(io.Discard, pr)
+        verdicts <- verdict{result, err}
+    }()
+
+    putErr := h.storage.Put(ctx, key, io.TeeReader(data, pw), size, contentType)
+    pw.CloseWithError(putErr)
+    scanned := <-verdicts
+
+    if putErr != nil {
+        return nil, putErr
+    }
+    if scanned.err != nil {
+        h.storage.Delete(ctx, key)
+        return nil, fmt.Errorf("error scanning upload: %w", scanned.err)
+    }
+    return scanned.result, nil
+}
+
+// quarantine moves a flagged file out of reach and records it for review.
+// Failures are only logged; the upload is rejected either way.
+func (h *FileHandler) quarantine(ctx context.Context, attachment *models.Attachment, signature string) {
+    log.Printf("upload %q from user %s flagged: %s", attachment.FileName, attachment.UploaderID, signature)
+
+    key := "quarantine/" + attachment.StorageKey
+    if err := h.moveObject(ctx, attachment.StorageKey, key); err != nil {
+        log.Printf("error quarantining %s: %v", attachment.StorageKey, err)
+        h.storage.Delete(ctx, attachment.StorageKey)
+        return
+    }
+
+    err := h.attachmentRepo.Quarantine(&models.QuarantinedFile{
+        UploaderID:  attachment.UploaderID,
+        StorageKey:  key,
+        FileName:    attachment.FileName,
+        ContentType: attachment.ContentType,
+        Size:        attachment.Size,
+        Signature:   signature,
+    })
+    if err != nil {
+        log.Printf("error recording quarantined file %s: %v", key, err)
+    }
+}
+
+// moveObject copies a stored object to a new key and deletes the original
+func (h *FileHandler) moveObject(ctx context.Context, from, to string) error {
+    body, object, err := h.storage.Get(ctx, from)
+    if err != nil {
+        return err
+    }
+    defer body.Close()
+
+    if err := h.storage.Put(ctx, to, body, object.Size, object.ContentType); err != nil {
+        return err
+    }
+    return h.storage.Delete(ctx, from)
+}
+
+// GetStorageUsage reports how much of their storage quota the user has used
+func (h *FileHandler) GetStorageUsage(w http.ResponseWriter, r *http.Request) {
+    claims, ok := middleware.GetUserFromContext(r.Context())
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    usage, err := h.attachmentRepo.StorageUsage(claims.UserID, h.policy.UserQuota)
+    if err != nil {
+        http.Error(w, "Error fetching storage usage: "+err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(usage)
+}
+
+func uploadResponse(attachment *models.Attachment) FileUploadResponse {
+    fileType := "file"
+    if attachment.Width != nil {
+        fileType = "image"
+    }
+
+    return FileUploadResponse{
+        ID:          attachment.ID,
+        Type:        fileType,
+        URL:         models.AttachmentURL(attachment.ID),
+        FileName:    attachment.FileName,
+        FileSize:    attachment.Size,
+        ContentType: attachment.ContentType,
+        Width:       attachment.Width,
+        Height:      attachment.Height,
+        Thumbnails:  attachment.Thumbnails,
+    }
+}
 
-    // Create file
-    dst, err := os.Create(filepath)
+// findAccessible loads an attachment the user may read: their own upload,
+// or one sent to a room they are a member of. Anything else is reported as
+// missing, so outsiders can't probe for IDs.
+func (h *FileHandler) findAccessible(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*models.Attachment, bool) {
+    vars := mux.Vars(r)
+    attachmentID, err := uuid.Parse(vars["attachmentId"])
     if err != nil {
-        http.Error(w, "Error saving file", http.StatusInternalServerError)
+        http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+        return nil, false
+    }
+
+    attachment, err := h.attachmentRepo.FindByID(attachmentID)
+    if errors.Is(err, repository.ErrAttachmentNotFound) {
+        http.Error(w, "Attachment not found", http.StatusNotFound)
+        return nil, false
+    }
+    if err != nil {
+        http.Error(w, "Error fetching attachment", http.StatusInternalServerError)
+        return nil, false
+    }
+
+    if attachment.UploaderID == userID {
+        return attachment, true
+    }
+
+    if attachment.RoomID != nil {
+        isMember, err := h.roomRepo.IsMember(*attachment.RoomID, userID)
+        if err != nil {
+            http.Error(w, "Error checking membership", http.StatusInternalServerError)
+            return nil, false
+        }
+        if isMember {
+            return attachment, true
+        }
+    }
+
+    http.Error(w, "Attachment not found", http.StatusNotFound)
+    return nil, false
+}
+
+// GetAttachment streams an attachment to its uploader or a member of the
+// room it was sent in
+func (h *FileHandler) GetAttachment(w http.ResponseWriter, r *http.Request) {
+    claims, ok := middleware.GetUserFromContext(r.Context())
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    attachment, ok := h.findAccessible(w, r, claims.UserID)
+    if !ok {
+        return
+    }
+
+    h.serveAttachment(w, r, attachment)
+}
+
+// GetThumbnail streams a thumbnail of an image attachment
+func (h *FileHandler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+    claims, ok := middleware.GetUserFromContext(r.Context())
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
         return
     }
-    defer dst.Close()
 
-    // Copy file content
-    fileSize, err := io.Copy(dst, file)
+    attachment, ok := h.findAccessible(w, r, claims.UserID)
+    if !ok {
+        return
+    }
+
+    size, err := strconv.Atoi(mux.Vars(r)["size"])
     if err != nil {
-        http.Error(w, "Error saving file", http.StatusInternalServerError)
+        http.Error(w, "Invalid thumbnail size", http.StatusBadRequest)
         return
     }
 
-    // Return file URL
-    fileURL := fmt.Sprintf("/uploads/%s", filename)
+    h.serveThumbnail(w, r, attachment, size)
+}
+
+// GetAttachmentURL returns a short-lived link that downloads the attachment
+// without an Authorization header, for <img> tags and plain links. With
+// ?size=N the link is to that thumbnail instead.
+func (h *FileHandler) GetAttachmentURL(w http.ResponseWriter, r *http.Request) {
+    claims, ok := middleware.GetUserFromContext(r.Context())
+    if !ok {
+        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        return
+    }
 
-    response := FileUploadResponse{
-        URL:      fileURL,
-        FileName: handler.Filename,
-        FileSize: fileSize,
+    attachment, ok := h.findAccessible(w, r, claims.UserID)
+    if !ok {
+        return
+    }
+
+    expiresAt := time.Now().Add(signedURLTTL)
+    query := url.Values{}
+    if size := r.URL.Query().Get("size"); size != "" {
+        n, err := strconv.Atoi(size)
+        if _, ok := attachment.Thumbnails.Find(n); err != nil || !ok {
+            http.Error(w, "Thumbnail not found", http.StatusNotFound)
+            return
+        }
+        query.Set("size", size)
+    }
+    query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+    query.Set("signature", h.signer.Sign(signedValue(attachment.ID), expiresAt))
+
+    response := SignedURLResponse{
+        URL:       models.AttachmentURL(attachment.ID) + "/download?" + query.Encode(),
+        ExpiresAt: expiresAt,
     }
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+
+// DownloadSigned streams an attachment for a link made by GetAttachmentURL
+func (h *FileHandler) DownloadSigned(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    attachmentID, err := uuid.Parse(vars["attachmentId"])
+    if err != nil {
+        http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+        return
+    }
+
+    expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+    if err != nil || !h.signer.Verify(signedValue(attachmentID), time.Unix(expires, 0), r.URL.Query().Get("signature")) {
+        http.Error(w, "Invalid or expired link", http.StatusForbidden)
+        return
+    }
+
+    attachment, err := h.attachmentRepo.FindByID(attachmentID)
+    if errors.Is(err, repository.ErrAttachmentNotFound) {
+        http.Error(w, "Attachment not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, "Error fetching attachment", http.StatusInternalServerError)
+        return
+    }
+
+    if size := r.URL.Query().Get("size"); size != "" {
+        n, err := strconv.Atoi(size)
+        if err != nil {
+            http.Error(w, "Invalid thumbnail size", http.StatusBadRequest)
+            return
+        }
+        h.serveThumbnail(w, r, attachment, n)
+        return
+    }
+
+    h.serveAttachment(w, r, attachment)
+}
+
+// serveThumbnail streams the attachment's thumbnail of the given size
+func (h *FileHandler) serveThumbnail(w http.ResponseWriter, r *http.Request, attachment *models.Attachment, size int) {
+    if _, ok := attachment.Thumbnails.Find(size); !ok {
+        http.Error(w, "Thumbnail not found", http.StatusNotFound)
+        return
+    }
+
+    h.serveObject(w, r, thumbnailKey(attachment.StorageKey, size), imaging.ThumbnailContentType(attachment.ContentType), attachment.FileName)
+}
+
+// serveAttachment streams the stored file under the uploaded file name
+func (h *FileHandler) serveAttachment(w http.ResponseWriter, r *http.Request, attachment *models.Attachment) {
+    h.serveObject(w, r, attachment.StorageKey, attachment.ContentType, attachment.FileName)
+}
+
+// serveObject streams a stored object as fileName. Safe images are shown
+// inline unless ?download is set.
+func (h *FileHandler) serveObject(w http.ResponseWriter, r *http.Request, key, contentType, fileName string) {
+    body, object, err := h.storage.Get(r.Context(), key)
+    if errors.Is(err, storage.ErrNotFound) {
+        http.Error(w, "File not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        log.Printf("error reading %s: %v", key, err)
+        http.Error(w, "Error reading file", http.StatusInternalServerError)
+        return
+    }
+    defer body.Close()
+
+    if contentType == "" {
+        contentType = object.ContentType
+    }
+    if contentType == "" {
+        contentType = "application/octet-stream"
+    }
+
+    disposition := "attachment"
+    mediaType, _, _ := mime.ParseMediaType(contentType)
+    if inlineContentTypes[mediaType] && !r.URL.Query().Has("download") {
+        disposition = "inline"
+    }
+    if value := mime.FormatMediaType(disposition, map[string]string{"filename": fileName}); value != "" {
+        disposition = value
+    }
+
+    w.Header().Set("Content-Type", contentType)
+    w.Header().Set("Content-Disposition", disposition)
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.Header().Set("Cache-Control", "private, max-age=3600")
+
+    writeObject(w, r, fileName, body, object)
+}
+
+// ServeUpload streams a locally stored file for a URL signed by the
+// storage backend. Files are never served without a signature.
+func (h *FileHandler) ServeUpload(w http.ResponseWriter, r *http.Request) {
+    key := mux.Vars(r)["key"]
+
+    verifier, ok := h.storage.(interface {
+        VerifySignedURL(key string, query url.Values) bool
+    })
+    if !ok || !verifier.VerifySignedURL(key, r.URL.Query()) {
+        http.Error(w, "Invalid or expired link", http.StatusForbidden)
+        return
+    }
+
+    body, object, err := h.storage.Get(r.Context(), key)
+    if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+        http.Error(w, "File not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, "Error reading file", http.StatusInternalServerError)
+        return
+    }
+    defer body.Close()
+
+    if object.ContentType != "" {
+        w.Header().Set("Content-Type", object.ContentType)
+    }
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+
+    writeObject(w, r, key, body, object)
+}
+
+// writeObject copies a stored object to the response
+func writeObject(w http.ResponseWriter, r *http.Request, name string, body io.Reader, object *storage.Object) {
+    // Local files can be seeked, which gives range requests and caching
+    if seeker, ok := body.(io.ReadSeeker); ok {
+        http.ServeContent(w, r, name, object.ModTime, seeker)
+        return
+    }
+
+    if object.Size >= 0 {
+        w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+    }
+    if !object.ModTime.IsZero() {
+        w.Header().Set("Last-Modified", object.ModTime.UTC().Format(http.TimeFormat))
+    }
+    io.Copy(w, body)
+}
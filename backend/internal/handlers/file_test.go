@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/halizadz/chat-app-backend/internal/middleware"
+	"github.com/halizadz/chat-app-backend/internal/repository"
+	"github.com/halizadz/chat-app-backend/internal/scanner"
+	"github.com/halizadz/chat-app-backend/internal/storage"
+	"github.com/halizadz/chat-app-backend/internal/utils"
+)
+
+// uploadRequest returns an authenticated multipart upload of data
+func uploadRequest(t *testing.T, userID uuid.UUID, fileName string, data []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	ctx := context.WithValue(r.Context(), middleware.UserContextKey, &utils.Claims{UserID: userID})
+	return r.WithContext(ctx)
+}
+
+func TestUploadFileRejectsOversizedBody(t *testing.T) {
+	h := &FileHandler{policy: UploadPolicy{MaxUploadSize: 1 << 10}}
+
+	w := httptest.NewRecorder()
+	h.UploadFile(w, uploadRequest(t, uuid.New(), "big.txt", bytes.Repeat([]byte("a"), 4<<10)))
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+// recordingDriver is a database/sql driver that records the statements run
+// through it. Queries return a single row holding the current time, which
+// is what the RETURNING created_at inserts expect.
+type recordingDriver struct {
+	mu         sync.Mutex
+	statements []recordedStatement
+}
+
+type recordedStatement struct {
+	query string
+	args  []driver.Value
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return recordingConn{d}, nil
+}
+
+func (d *recordingDriver) recorded() []recordedStatement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]recordedStatement(nil), d.statements...)
+}
+
+type recordingConn struct{ driver *recordingDriver }
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return recordingStmt{c.driver, query}, nil
+}
+
+func (c recordingConn) Close() error { return nil }
+
+func (c recordingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type recordingStmt struct {
+	driver *recordingDriver
+	query  string
+}
+
+func (s recordingStmt) Close() error  { return nil }
+func (s recordingStmt) NumInput() int { return -1 }
+
+func (s recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.record(args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.record(args)
+	return &timeRows{}, nil
+}
+
+func (s recordingStmt) record(args []driver.Value) {
+	s.driver.mu.Lock()
+	defer s.driver.mu.Unlock()
+	s.driver.statements = append(s.driver.statements, recordedStatement{s.query, args})
+}
+
+type timeRows struct{ done bool }
+
+func (r *timeRows) Columns() []string { return []string{"created_at"} }
+func (r *timeRows) Close() error      { return nil }
+
+func (r *timeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = time.Now()
+	return nil
+}
+
+var (
+	recording    = &recordingDriver{}
+	registerOnce sync.Once
+)
+
+// newRecordingDB returns a database whose statements end up in recording
+func newRecordingDB(t *testing.T) *sql.DB {
+	registerOnce.Do(func() { sql.Register("recording", recording) })
+	db, err := sql.Open("recording", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// infectedScanner flags every file
+type infectedScanner struct{}
+
+func (infectedScanner) Scan(ctx context.Context, r io.Reader) (*scanner.Result, error) {
+	return &scanner.Result{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+}
+
+func TestStoreAttachmentQuarantinesInfectedFiles(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewLocalStorage(dir, "/files", storage.NewSigner("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &FileHandler{
+		storage:        store,
+		scanner:        infectedScanner{},
+		attachmentRepo: repository.NewAttachmentRepository(newRecordingDB(t)),
+		policy:         UploadPolicy{AllowedTypes: []string{"image/*"}},
+	}
+
+	// Large enough to get thumbnails, which are removed
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewRGBA(image.Rect(0, 0, 600, 600))); err != nil {
+		t.Fatal(err)
+	}
+
+	userID := uuid.New()
+	_, err = h.storeAttachment(context.Background(), userID, "photo.png", bytes.NewReader(data.Bytes()), int64(data.Len()))
+	if !errors.Is(err, errQuarantined) {
+		t.Fatalf("storeAttachment() error = %v, want %v", err, errQuarantined)
+	}
+
+	var files []string
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if len(files) != 1 || !strings.HasPrefix(files[0], "quarantine/"+userID.String()+"-") {
+		t.Fatalf("stored files = %v, want only the quarantined upload", files)
+	}
+
+	var record *recordedStatement
+	for _, statement := range recording.recorded() {
+		if strings.Contains(statement.query, "INSERT INTO quarantined_files") && statement.args[2] == files[0] {
+			record = &statement
+		}
+	}
+	if record == nil {
+		t.Fatalf("no quarantined_files record for %s", files[0])
+	}
+	if record.args[6] != "Eicar-Test-Signature" {
+		t.Errorf("recorded signature = %v, want Eicar-Test-Signature", record.args[6])
+	}
+}
@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/halizadz/chat-app-backend/internal/middleware"
+	"github.com/halizadz/chat-app-backend/internal/models"
+	"github.com/halizadz/chat-app-backend/internal/repository"
+	"github.com/halizadz/chat-app-backend/internal/utils"
+	ws "github.com/halizadz/chat-app-backend/internal/websocket"
+)
+
+type CreateInviteLinkRequest struct {
+	ExpiresIn int  `json:"expires_in"` // Seconds until the link expires; 0 never expires
+	MaxUses   *int `json:"max_uses"`   // Omit for unlimited uses
+}
+
+// inviteMember creates a pending invitation for userID to join a group
+// room and lets the invitee know over the WebSocket
+func (h *ChatHandler) inviteMember(w http.ResponseWriter, claims *utils.Claims, room *models.Room, userID uuid.UUID) {
+	if _, err := h.userRepo.FindByID(userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	banned, err := h.roomRepo.IsBanned(room.ID, userID)
+	if err != nil {
+		http.Error(w, "Error checking bans", http.StatusInternalServerError)
+		return
+	}
+	if banned {
+		http.Error(w, "User is banned from this room", http.StatusForbidden)
+		return
+	}
+
+	invitation := &models.RoomInvitation{
+		RoomID:          room.ID,
+		RoomName:        room.Name,
+		InviterID:       claims.UserID,
+		InviterUsername: claims.Username,
+		InviteeID:       userID,
+	}
+
+	if err := h.invitationRepo.CreateInvitation(invitation); err != nil {
+		if errors.Is(err, repository.ErrAlreadyInvited) {
+			http.Error(w, "User already has a pending invitation to this room", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Error creating invitation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.hub.SendToUsers([]uuid.UUID{userID}, ws.InvitationEvent{
+		Type:       "invitation",
+		Invitation: invitation,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// GetRoomInvitations lists a room's pending invitations
+func (h *ChatHandler) GetRoomInvitations(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorize(w, roomID, claims.UserID, permAddMembers); !ok {
+		return
+	}
+
+	invitations, err := h.invitationRepo.GetPendingForRoom(roomID)
+	if err != nil {
+		http.Error(w, "Error fetching invitations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if invitations == nil {
+		invitations = []*models.RoomInvitation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// CancelInvitation withdraws a pending invitation to a room
+func (h *ChatHandler) CancelInvitation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	invitationID, err := uuid.Parse(vars["invitationId"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorize(w, roomID, claims.UserID, permAddMembers); !ok {
+		return
+	}
+
+	if err := h.invitationRepo.CancelInvitation(invitationID, roomID); err != nil {
+		if errors.Is(err, repository.ErrInvitationNotFound) {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error cancelling invitation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invitation cancelled successfully"})
+}
+
+// GetMyInvitations lists the caller's pending invitations
+func (h *ChatHandler) GetMyInvitations(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invitations, err := h.invitationRepo.GetPendingForUser(claims.UserID)
+	if err != nil {
+		http.Error(w, "Error fetching invitations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if invitations == nil {
+		invitations = []*models.RoomInvitation{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// AcceptInvitation joins the room the caller was invited to
+func (h *ChatHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	h.respondToInvitation(w, r, true)
+}
+
+// DeclineInvitation turns down an invitation
+func (h *ChatHandler) DeclineInvitation(w http.ResponseWriter, r *http.Request) {
+	h.respondToInvitation(w, r, false)
+}
+
+func (h *ChatHandler) respondToInvitation(w http.ResponseWriter, r *http.Request, accept bool) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	invitationID, err := uuid.Parse(vars["invitationId"])
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	invitation, err := h.invitationRepo.RespondToInvitation(invitationID, claims.UserID, accept)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvitationNotFound) {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrBanned) {
+			http.Error(w, "You are banned from this room", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Error responding to invitation: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitation)
+}
+
+// CreateInviteLink creates a shareable link for joining a group room
+func (h *ChatHandler) CreateInviteLink(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	var req CreateInviteLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.ExpiresIn < 0 {
+		http.Error(w, "expires_in cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	if req.MaxUses != nil && *req.MaxUses < 1 {
+		http.Error(w, "max_uses must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorize(w, roomID, claims.UserID, permAddMembers); !ok {
+		return
+	}
+
+	room, err := h.roomRepo.FindByID(roomID)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	if room.Type != "group" {
+		http.Error(w, "Invite links are only available for group rooms", http.StatusBadRequest)
+		return
+	}
+
+	code, err := utils.GenerateInviteCode()
+	if err != nil {
+		http.Error(w, "Error generating invite code", http.StatusInternalServerError)
+		return
+	}
+
+	link := &models.InviteLink{
+		RoomID:    roomID,
+		Code:      code,
+		CreatedBy: claims.UserID,
+		MaxUses:   req.MaxUses,
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		link.ExpiresAt = &expiresAt
+	}
+
+	if err := h.invitationRepo.CreateInviteLink(link); err != nil {
+		http.Error(w, "Error creating invite link: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+// GetInviteLinks lists a room's usable invite links
+func (h *ChatHandler) GetInviteLinks(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorize(w, roomID, claims.UserID, permAddMembers); !ok {
+		return
+	}
+
+	links, err := h.invitationRepo.GetInviteLinks(roomID)
+	if err != nil {
+		http.Error(w, "Error fetching invite links: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if links == nil {
+		links = []*models.InviteLink{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+// RevokeInviteLink stops an invite link from admitting anyone else
+func (h *ChatHandler) RevokeInviteLink(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	roomID, err := uuid.Parse(vars["roomId"])
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	linkID, err := uuid.Parse(vars["linkId"])
+	if err != nil {
+		http.Error(w, "Invalid link ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorize(w, roomID, claims.UserID, permAddMembers); !ok {
+		return
+	}
+
+	if err := h.invitationRepo.RevokeInviteLink(roomID, linkID); err != nil {
+		if errors.Is(err, repository.ErrInviteLinkInvalid) {
+			http.Error(w, "Invite link not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error revoking invite link: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Invite link revoked successfully"})
+}
+
+// AcceptInviteLink joins the caller to the room an invite link belongs to
+// and returns the room
+func (h *ChatHandler) AcceptInviteLink(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	code := vars["code"]
+
+	roomID, _, err := h.invitationRepo.RedeemInviteLink(code, claims.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrInviteLinkInvalid) {
+			http.Error(w, "Invite link is invalid or expired", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, repository.ErrBanned) {
+			http.Error(w, "You are banned from this room", http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Error joining room: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	room, err := h.roomRepo.FindByID(roomID)
+	if err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room)
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/halizadz/chat-app-backend/internal/linkpreview"
+	"github.com/halizadz/chat-app-backend/internal/models"
+	"github.com/halizadz/chat-app-backend/internal/repository"
+	ws "github.com/halizadz/chat-app-backend/internal/websocket"
+)
+
+const (
+	// How many previews are fetched at once, and how many messages may
+	// wait for one; past that, messages go without a preview
+	linkPreviewWorkers = 4
+	linkPreviewQueue   = 100
+
+	// How long fetched previews are reused, and how long a page without
+	// one is left alone
+	linkPreviewTTL     = 24 * time.Hour
+	linkPreviewMissTTL = time.Hour
+)
+
+type linkPreviewJob struct {
+	roomID    uuid.UUID
+	messageID uuid.UUID
+	url       string
+}
+
+// LinkPreviewer adds previews to messages containing links. Pages are
+// fetched in the background, so sending a message never waits on them;
+// the room hears about the preview through a message_updated event.
+type LinkPreviewer struct {
+	fetcher     *linkpreview.Fetcher
+	previewRepo *repository.LinkPreviewRepository
+	messageRepo *repository.MessageRepository
+	hub         *ws.Hub
+	jobs        chan linkPreviewJob
+}
+
+func NewLinkPreviewer(fetcher *linkpreview.Fetcher, previewRepo *repository.LinkPreviewRepository, messageRepo *repository.MessageRepository, hub *ws.Hub) *LinkPreviewer {
+	return &LinkPreviewer{
+		fetcher:     fetcher,
+		previewRepo: previewRepo,
+		messageRepo: messageRepo,
+		hub:         hub,
+		jobs:        make(chan linkPreviewJob, linkPreviewQueue),
+	}
+}
+
+// Enqueue schedules a preview for the first link in a stored message
+func (p *LinkPreviewer) Enqueue(message *models.Message) {
+	if message.Type != "text" {
+		return
+	}
+	link, ok := linkpreview.FindURL(message.Content)
+	if !ok {
+		return
+	}
+
+	select {
+	case p.jobs <- linkPreviewJob{roomID: message.RoomID, messageID: message.ID, url: link}:
+	default:
+		log.Printf("link preview queue full; skipping message %s", message.ID)
+	}
+}
+
+// Run fetches queued previews and clears out expired cache entries
+func (p *LinkPreviewer) Run() {
+	for i := 0; i < linkPreviewWorkers; i++ {
+		go func() {
+			for job := range p.jobs {
+				p.process(job)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := p.previewRepo.DeleteExpired(); err != nil {
+			log.Printf("error deleting expired link previews: %v", err)
+		}
+	}
+}
+
+func (p *LinkPreviewer) process(job linkPreviewJob) {
+	preview, err := p.preview(job.url)
+	if err != nil {
+		log.Printf("error previewing %s: %v", job.url, err)
+		return
+	}
+	if preview == nil {
+		return
+	}
+
+	updated, err := p.messageRepo.SetLinkPreview(job.messageID, job.url, preview)
+	if err != nil {
+		log.Printf("error saving link preview for message %s: %v", job.messageID, err)
+		return
+	}
+	if !updated {
+		return
+	}
+
+	p.hub.BroadcastToRoom(job.roomID, ws.MessageUpdatedEvent{
+		Type:        "message_updated",
+		RoomID:      job.roomID,
+		MessageID:   job.messageID,
+		LinkPreview: preview,
+	})
+}
+
+// preview returns the preview of url, from the cache when possible. It
+// returns nil if the page has none.
+func (p *LinkPreviewer) preview(url string) (*models.LinkPreview, error) {
+	cached, found, err := p.previewRepo.Find(url)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), linkpreview.Timeout)
+	defer cancel()
+
+	preview, err := p.fetcher.Fetch(ctx, url)
+	if err != nil {
+		// Failures are remembered too, so a dead or blocked link posted
+		// over and over is only fetched once in a while
+		if !errors.Is(err, linkpreview.ErrNoPreview) {
+			log.Printf("error fetching link preview for %s: %v", url, err)
+		}
+		if err := p.previewRepo.Save(url, nil, time.Now().Add(linkPreviewMissTTL)); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	if err := p.previewRepo.Save(url, preview, time.Now().Add(linkPreviewTTL)); err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
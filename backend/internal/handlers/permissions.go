@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/halizadz/chat-app-backend/internal/models"
+)
+
+// permission is an action a room member may take
+type permission int
+
+const (
+	// Read the room, its members and its history, post and react
+	permParticipate permission = iota
+	// Delete other members' messages
+	permModerateMessages
+	// Add members
+	permAddMembers
+	// Remove members who rank below you
+	permRemoveMembers
+	// Block users from joining the room
+	permBanMembers
+	// Rename the room or change its description and visibility
+	permUpdateRoom
+	// Change the roles of members who rank below you
+	permManageRoles
+	// Delete the room or hand it to someone else
+	permOwnRoom
+)
+
+// permissionRules gives the least privileged role holding each permission
+// and the message returned to members below it
+var permissionRules = map[permission]struct {
+	minRole string
+	denied  string
+}{
+	permParticipate:      {models.RoleMember, "Not a member of this room"},
+	permModerateMessages: {models.RoleModerator, "You can only delete your own messages"},
+	permAddMembers:       {models.RoleModerator, "Only moderators and above can add members"},
+	permRemoveMembers:    {models.RoleModerator, "Only moderators and above can remove members"},
+	permBanMembers:       {models.RoleAdmin, "Only admins and above can ban users"},
+	permUpdateRoom:       {models.RoleAdmin, "Only admins and above can update room"},
+	permManageRoles:      {models.RoleAdmin, "Only admins and above can change roles"},
+	permOwnRoom:          {models.RoleOwner, "Only the room owner can do that"},
+}
+
+// authorize checks that the user is a member of the room holding perm and
+// returns their role. On failure it writes the error response and returns
+// false.
+func (h *ChatHandler) authorize(w http.ResponseWriter, roomID, userID uuid.UUID, perm permission) (string, bool) {
+	role, err := h.roomRepo.GetMemberRole(roomID, userID)
+	if err != nil {
+		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		return "", false
+	}
+
+	if role == "" {
+		http.Error(w, "Not a member of this room", http.StatusForbidden)
+		return "", false
+	}
+
+	if !allowed(role, perm) {
+		http.Error(w, permissionRules[perm].denied, http.StatusForbidden)
+		return "", false
+	}
+
+	return role, true
+}
+
+// allowed reports whether a member with role may do perm. Non-members have
+// no role and are never allowed.
+func allowed(role string, perm permission) bool {
+	return role != "" && models.RoleRank(role) >= models.RoleRank(permissionRules[perm].minRole)
+}
+
+// outranks reports whether a member with role may act on one with target
+func outranks(role, target string) bool {
+	return models.RoleRank(role) > models.RoleRank(target)
+}
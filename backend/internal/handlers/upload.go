@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/halizadz/chat-app-backend/internal/middleware"
+	"github.com/halizadz/chat-app-backend/internal/models"
+	"github.com/halizadz/chat-app-backend/internal/repository"
+	"github.com/halizadz/chat-app-backend/internal/storage"
+)
+
+const (
+	// maxChunkSize bounds each PUT, which is held in memory while it is
+	// hashed and stored
+	maxChunkSize = 16 << 20
+
+	// How many expired uploads are removed per query
+	uploadCleanupBatch = 100
+)
+
+type CreateUploadRequest struct {
+	FileName string `json:"file_name"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"` // SHA-256 of the whole file, hex encoded
+}
+
+type CompleteUploadRequest struct {
+	Checksum string `json:"checksum"`
+}
+
+// UploadStatus is an upload plus what the client needs to continue it
+type UploadStatus struct {
+	*models.Upload
+	MaxChunkSize int64 `json:"max_chunk_size"`
+}
+
+func validChecksum(checksum string) bool {
+	if len(checksum) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(checksum)
+	return err == nil
+}
+
+// restoreHash resumes the SHA-256 of an upload from its saved state
+func restoreHash(state []byte) (hash.Hash, error) {
+	hasher := sha256.New()
+	if len(state) > 0 {
+		if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+			return nil, err
+		}
+	}
+	return hasher, nil
+}
+
+func (h *FileHandler) writeUploadStatus(w http.ResponseWriter, upload *models.Upload, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(UploadStatus{Upload: upload, MaxChunkSize: maxChunkSize})
+}
+
+func (h *FileHandler) findUpload(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*models.Upload, bool) {
+	vars := mux.Vars(r)
+	uploadID, err := uuid.Parse(vars["uploadId"])
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	upload, err := h.uploadRepo.FindForUser(uploadID, userID)
+	if errors.Is(err, repository.ErrUploadNotFound) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Error fetching upload", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return upload, true
+}
+
+// CreateUpload starts a chunked upload. The client then PUTs the file in
+// order, chunk by chunk, and completes it with the file's checksum.
+func (h *FileHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	fileName := filepath.Base(strings.TrimSpace(req.FileName))
+	if fileName == "" || fileName == "." || fileName == "/" {
+		http.Error(w, "File name is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Size <= 0 {
+		http.Error(w, "Size must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.Size > h.policy.MaxUploadSize {
+		http.Error(w, fmt.Sprintf("File exceeds the %d MB upload limit", h.policy.MaxUploadSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Fail early rather than after the whole file has been sent; the quota
+	// is checked for real when the upload completes
+	usage, err := h.attachmentRepo.StorageUsage(claims.UserID, h.policy.UserQuota)
+	if err != nil {
+		http.Error(w, "Error fetching storage usage: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if usage.Remaining != nil && req.Size > *usage.Remaining {
+		http.Error(w, "Storage quota exceeded", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	upload := &models.Upload{
+		UserID:    claims.UserID,
+		FileName:  fileName,
+		Size:      req.Size,
+		ExpiresAt: time.Now().Add(h.policy.UploadExpiry),
+	}
+
+	if req.Checksum != "" {
+		checksum := strings.ToLower(req.Checksum)
+		if !validChecksum(checksum) {
+			http.Error(w, "Checksum must be a hex-encoded SHA-256", http.StatusBadRequest)
+			return
+		}
+		upload.Checksum = &checksum
+	}
+
+	if err := h.uploadRepo.Create(upload); err != nil {
+		http.Error(w, "Error creating upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeUploadStatus(w, upload, http.StatusCreated)
+}
+
+// GetUpload reports how much of an upload has arrived, so an interrupted
+// client knows where to resume
+func (h *FileHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	upload, ok := h.findUpload(w, r, claims.UserID)
+	if !ok {
+		return
+	}
+
+	h.writeUploadStatus(w, upload, http.StatusOK)
+}
+
+// UploadChunk stores the request body as the chunk starting at ?offset,
+// which must be where the previous chunk ended
+func (h *FileHandler) UploadChunk(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	upload, ok := h.findUpload(w, r, claims.UserID)
+	if !ok {
+		return
+	}
+
+	if upload.Status != "pending" {
+		http.Error(w, "Upload is already complete", http.StatusConflict)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset", http.StatusBadRequest)
+		return
+	}
+	if offset != upload.Received {
+		http.Error(w, fmt.Sprintf("Expected offset %d", upload.Received), http.StatusConflict)
+		return
+	}
+
+	limit := min(int64(maxChunkSize), upload.Size-upload.Received)
+	chunk, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		http.Error(w, "Error reading chunk", http.StatusBadRequest)
+		return
+	}
+	if len(chunk) == 0 {
+		http.Error(w, "Chunk is empty", http.StatusBadRequest)
+		return
+	}
+	if int64(len(chunk)) > limit {
+		http.Error(w, fmt.Sprintf("Chunk may be at most %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// The first chunk usually shows what the file is, so a disallowed type
+	// needn't be sent in full. Completing the upload checks again.
+	if offset == 0 && (len(chunk) >= sniffLength || int64(len(chunk)) == upload.Size) {
+		if contentType := sniffContentType(chunk[:min(len(chunk), sniffLength)], upload.FileName); !allowedContentType(h.policy.AllowedTypes, contentType) {
+			http.Error(w, fmt.Sprintf("%v: %s", errUnsupportedType, contentType), http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	hasher, err := restoreHash(upload.HashState)
+	if err != nil {
+		http.Error(w, "Error resuming upload", http.StatusInternalServerError)
+		return
+	}
+	hasher.Write(chunk)
+	hashState, err := hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		http.Error(w, "Error resuming upload", http.StatusInternalServerError)
+		return
+	}
+
+	// Each attempt gets its own key, so a losing concurrent PUT can't
+	// overwrite the chunk that won
+	key := fmt.Sprintf("uploads/%s/%d-%s", upload.ID, offset, uuid.New())
+	if err := h.storage.Put(r.Context(), key, bytes.NewReader(chunk), int64(len(chunk)), "application/octet-stream"); err != nil {
+		http.Error(w, "Error saving chunk", http.StatusInternalServerError)
+		return
+	}
+
+	chunkInfo := models.UploadChunk{Position: offset, Size: int64(len(chunk)), StorageKey: key}
+	expiresAt := time.Now().Add(h.policy.UploadExpiry)
+	if err := h.uploadRepo.AppendChunk(upload.ID, chunkInfo, hashState, expiresAt); err != nil {
+		h.storage.Delete(r.Context(), key)
+		if errors.Is(err, repository.ErrUploadConflict) {
+			http.Error(w, "Upload changed concurrently; fetch it for the current offset", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Error saving chunk: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	upload.Received += chunkInfo.Size
+	upload.ExpiresAt = expiresAt
+	h.writeUploadStatus(w, upload, http.StatusOK)
+}
+
+// CompleteUpload verifies the checksum of a fully received upload and
+// turns it into an attachment. Completing an upload twice returns the same
+// attachment.
+func (h *FileHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	upload, ok := h.findUpload(w, r, claims.UserID)
+	if !ok {
+		return
+	}
+
+	// A retry after a dropped response
+	if upload.Status == "completed" && upload.AttachmentID != nil {
+		attachment, err := h.attachmentRepo.FindByID(*upload.AttachmentID)
+		if err != nil {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(uploadResponse(attachment))
+		return
+	}
+
+	var req CompleteUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	checksum := strings.ToLower(req.Checksum)
+	if upload.Checksum != nil {
+		if checksum != "" && checksum != *upload.Checksum {
+			http.Error(w, "Checksum differs from the one the upload was created with", http.StatusBadRequest)
+			return
+		}
+		checksum = *upload.Checksum
+	}
+	if !validChecksum(checksum) {
+		http.Error(w, "A hex-encoded SHA-256 checksum is required", http.StatusBadRequest)
+		return
+	}
+
+	if upload.Received != upload.Size {
+		http.Error(w, fmt.Sprintf("Upload is incomplete: %d of %d bytes received", upload.Received, upload.Size), http.StatusConflict)
+		return
+	}
+
+	if err := h.uploadRepo.BeginComplete(upload.ID, claims.UserID, time.Now().Add(h.policy.UploadExpiry)); err != nil {
+		if errors.Is(err, repository.ErrUploadConflict) {
+			http.Error(w, "Upload is already being completed", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Error completing upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hasher, err := restoreHash(upload.HashState)
+	if err != nil {
+		h.uploadRepo.AbortComplete(upload.ID)
+		http.Error(w, "Error completing upload", http.StatusInternalServerError)
+		return
+	}
+
+	// The received bytes are wrong and can't be rewound, so start over
+	if hex.EncodeToString(hasher.Sum(nil)) != checksum {
+		h.uploadRepo.AbortComplete(upload.ID)
+		h.deleteUpload(r.Context(), upload.ID, claims.UserID)
+		http.Error(w, "Checksum mismatch; the upload was discarded", http.StatusUnprocessableEntity)
+		return
+	}
+
+	chunks, err := h.uploadRepo.GetChunks(upload.ID)
+	if err != nil {
+		h.uploadRepo.AbortComplete(upload.ID)
+		http.Error(w, "Error completing upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body := &chunkReader{ctx: r.Context(), storage: h.storage, chunks: chunks}
+	attachment, err := h.storeAttachment(r.Context(), claims.UserID, upload.FileName, body, upload.Size)
+	body.Close()
+	if err != nil {
+		h.uploadRepo.AbortComplete(upload.ID)
+		writeUploadError(w, err)
+		return
+	}
+
+	if err := h.uploadRepo.FinishComplete(upload.ID, attachment.ID); err != nil {
+		log.Printf("error finishing upload %s: %v", upload.ID, err)
+	}
+	for _, chunk := range chunks {
+		h.storage.Delete(r.Context(), chunk.StorageKey)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploadResponse(attachment))
+}
+
+// CancelUpload abandons an upload and deletes what has arrived
+func (h *FileHandler) CancelUpload(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	uploadID, err := uuid.Parse(vars["uploadId"])
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.deleteUpload(r.Context(), uploadID, claims.UserID); err != nil {
+		if errors.Is(err, repository.ErrUploadNotFound) {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Error cancelling upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Upload cancelled successfully"})
+}
+
+func (h *FileHandler) deleteUpload(ctx context.Context, uploadID, userID uuid.UUID) error {
+	keys, err := h.uploadRepo.Delete(uploadID, userID)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		h.storage.Delete(ctx, key)
+	}
+	return nil
+}
+
+// RunUploadCleanup deletes expired uploads and their chunks every interval.
+// Replicas can all run it; each expired upload is removed by only one.
+func (h *FileHandler) RunUploadCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for {
+			keys, deleted, err := h.uploadRepo.DeleteExpired(uploadCleanupBatch)
+			if err != nil {
+				log.Printf("error deleting expired uploads: %v", err)
+				break
+			}
+			for _, key := range keys {
+				if err := h.storage.Delete(context.Background(), key); err != nil {
+					log.Printf("error deleting upload chunk %s: %v", key, err)
+				}
+			}
+			if deleted < uploadCleanupBatch {
+				break
+			}
+		}
+	}
+}
+
+// chunkReader reads an upload's chunks back as one stream, opening each
+// only when the previous one is used up
+type chunkReader struct {
+	ctx     context.Context
+	storage storage.Storage
+	chunks  []models.UploadChunk
+	current io.ReadCloser
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.chunks) == 0 {
+				return 0, io.EOF
+			}
+			body, _, err := c.storage.Get(c.ctx, c.chunks[0].StorageKey)
+			if err != nil {
+				return 0, fmt.Errorf("error reading chunk at %d: %w", c.chunks[0].Position, err)
+			}
+			c.current = body
+			c.chunks = c.chunks[1:]
+		}
+
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *chunkReader) Close() error {
+	if c.current != nil {
+		return c.current.Close()
+	}
+	return nil
+}
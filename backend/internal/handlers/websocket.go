@@ -2,8 +2,11 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
@@ -25,18 +28,26 @@ var upgrader = websocket.Upgrader{
 }
 
 type WebSocketHandler struct {
-	hub         *ws.Hub
-	roomRepo    *repository.RoomRepository
-	messageRepo *repository.MessageRepository
-	jwtSecret   string
+	hub            *ws.Hub
+	roomRepo       *repository.RoomRepository
+	messageRepo    *repository.MessageRepository
+	attachmentRepo *repository.AttachmentRepository
+	sessionRepo    *repository.SessionRepository
+	jwtSecret      string
+	roomQuota      int64 // Default storage quota of each room
+	previewer      *LinkPreviewer
 }
 
-func NewWebSocketHandler(hub *ws.Hub, roomRepo *repository.RoomRepository, messageRepo *repository.MessageRepository, jwtSecret string) *WebSocketHandler {
+func NewWebSocketHandler(hub *ws.Hub, roomRepo *repository.RoomRepository, messageRepo *repository.MessageRepository, attachmentRepo *repository.AttachmentRepository, sessionRepo *repository.SessionRepository, jwtSecret string, roomQuota int64, previewer *LinkPreviewer) *WebSocketHandler {
 	return &WebSocketHandler{
-		hub:         hub,
-		roomRepo:    roomRepo,
-		messageRepo: messageRepo,
-		jwtSecret:   jwtSecret,
+		hub:            hub,
+		roomRepo:       roomRepo,
+		messageRepo:    messageRepo,
+		attachmentRepo: attachmentRepo,
+		sessionRepo:    sessionRepo,
+		jwtSecret:      jwtSecret,
+		roomQuota:      roomQuota,
+		previewer:      previewer,
 	}
 }
 
@@ -52,7 +63,7 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	if tokenString != "" {
 		log.Printf("Validating token from query parameter")
 		var err error
-		claims, err = utils.ValidateToken(tokenString, h.jwtSecret)
+		claims, err = middleware.Authenticate(tokenString, h.jwtSecret, h.sessionRepo)
 		if err != nil {
 			log.Printf("Token validation failed: %v", err)
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -69,48 +80,167 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	vars := mux.Vars(r)
-	roomID, err := uuid.Parse(vars["roomId"])
+	// Legacy /api/ws/{roomId} connections are subscribed to that room
+	// straight away; /api/ws connections subscribe with frames.
+	var roomID uuid.UUID
+	var lastSeq int64
+	if roomIDStr, ok := mux.Vars(r)["roomId"]; ok {
+		var err error
+		roomID, err = uuid.Parse(roomIDStr)
+		if err != nil {
+			log.Printf("Invalid room ID: %v", err)
+			http.Error(w, "Invalid room ID", http.StatusBadRequest)
+			return
+		}
+
+		isMember, err := h.roomRepo.IsMember(roomID, claims.UserID)
+		if err != nil {
+			log.Printf("Error checking membership: %v", err)
+			http.Error(w, "Error checking membership", http.StatusInternalServerError)
+			return
+		}
+
+		if !isMember {
+			log.Printf("User %s is not a member of room %s", claims.Username, roomID)
+			http.Error(w, "Not a member of this room", http.StatusForbidden)
+			return
+		}
+
+		if lastSeqStr := r.URL.Query().Get("last_seq"); lastSeqStr != "" {
+			lastSeq, err = strconv.ParseInt(lastSeqStr, 10, 64)
+			if err != nil || lastSeq < 0 {
+				http.Error(w, "Invalid last_seq", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	log.Printf("Upgrading connection to WebSocket for user %s", claims.Username)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Invalid room ID: %v", err)
-		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		log.Printf("Error upgrading connection: %v", err)
 		return
 	}
 
-	log.Printf("Checking room membership for user %s in room %s", claims.Username, roomID)
-	// Check if user is member of room
-	isMember, err := h.roomRepo.IsMember(roomID, claims.UserID)
+	log.Printf("WebSocket connected: user=%s", claims.Username)
+	client := ws.NewClient(h.hub, conn, claims.UserID, claims.SessionID, claims.Username)
+
+	// The client must be registered and drained before anything is
+	// replayed to it, or replayed frames could be dropped
+	h.hub.RegisterClient(client)
+	go client.WritePump()
+
+	if roomID != uuid.Nil {
+		h.hub.JoinRoom(client, roomID)
+		if lastSeq > 0 {
+			h.replay(client, roomID, lastSeq)
+		}
+	}
+
+	go h.readPump(client, roomID)
+}
+
+// subscribe joins the client to a room after checking membership and
+// replays anything it missed after lastSeq
+func (h *WebSocketHandler) subscribe(client *ws.Client, roomID uuid.UUID, lastSeq int64) {
+	isMember, err := h.roomRepo.IsMember(roomID, client.UserID)
 	if err != nil {
 		log.Printf("Error checking membership: %v", err)
-		http.Error(w, "Error checking membership", http.StatusInternalServerError)
+		h.hub.SendTo(client, ws.Error{Type: "error", RoomID: roomID, Content: "Error checking membership"})
 		return
 	}
 
 	if !isMember {
-		log.Printf("User %s is not a member of room %s", claims.Username, roomID)
-		http.Error(w, "Not a member of this room", http.StatusForbidden)
+		log.Printf("User %s is not a member of room %s", client.Username, roomID)
+		h.hub.SendTo(client, ws.Error{Type: "error", RoomID: roomID, Content: "Not a member of this room"})
 		return
 	}
 
-	log.Printf("Upgrading connection to WebSocket for user %s", claims.Username)
-	conn, err := upgrader.Upgrade(w, r, nil)
+	if !h.hub.IsSubscribed(client, roomID) {
+		h.hub.JoinRoom(client, roomID)
+	}
+
+	if lastSeq > 0 {
+		h.replay(client, roomID, lastSeq)
+	}
+}
+
+// maxReplay caps how many missed messages are replayed over the socket;
+// beyond that the client is told to reload history over REST instead
+const maxReplay = 200
+
+// replay sends the client every message in the room after lastSeq. It runs
+// after the client joined the room, so a live message may arrive before
+// older replayed ones; clients order and deduplicate by seq. Replayed
+// frames wait for room in the send buffer; if one still can't be queued,
+// the client is told to resync rather than left with a gap.
+func (h *WebSocketHandler) replay(client *ws.Client, roomID uuid.UUID, lastSeq int64) {
+	missed, err := h.messageRepo.GetAfterSeq(roomID, client.UserID, lastSeq, maxReplay+1)
 	if err != nil {
-		log.Printf("Error upgrading connection: %v", err)
+		log.Printf("Error loading missed messages: %v", err)
+		h.hub.SendTo(client, ws.Resync{Type: "resync", RoomID: roomID})
 		return
 	}
 
-	log.Printf("WebSocket connected: user=%s room=%s", claims.Username, roomID)
-	client := ws.NewClient(h.hub, conn, claims.UserID, claims.Username)
+	if len(missed) > maxReplay {
+		h.hub.SendTo(client, ws.Resync{Type: "resync", RoomID: roomID})
+		return
+	}
 
-	h.hub.Register <- client
-	h.hub.JoinRoom(client, roomID)
+	for _, m := range missed {
+		msg := wsMessageFromModel(m)
+		msg.Replay = true
+		if !h.hub.SendToWait(client, msg, ws.WriteWait) {
+			log.Printf("Replay to client %s stalled, asking it to resync", client.Username)
+			h.hub.SendToWait(client, ws.Resync{Type: "resync", RoomID: roomID}, ws.WriteWait)
+			return
+		}
+	}
+}
 
-	// Start goroutines for reading and writing
-	go client.WritePump()
-	go h.readPump(client, roomID)
+// wsMessageFromModel converts a stored message into its WebSocket frame
+func wsMessageFromModel(m *models.Message) *ws.Message {
+	msg := &ws.Message{
+		ID:           m.ID,
+		Type:         m.Type,
+		RoomID:       m.RoomID,
+		SenderID:     m.SenderID,
+		Content:      m.Content,
+		ReplyToID:    m.ReplyToID,
+		ThreadRootID: m.ThreadRootID,
+		ReplyTo:      m.ReplyTo,
+		Seq:          m.Seq,
+		Timestamp:    m.CreatedAt,
+	}
+
+	// Plain chat messages are called "message" on the socket
+	if msg.Type == "text" {
+		msg.Type = "message"
+	}
+	if m.Sender != nil {
+		msg.Username = m.Sender.Username
+	}
+	if m.FileURL != nil {
+		msg.FileURL = *m.FileURL
+	}
+	if m.FileName != nil {
+		msg.FileName = *m.FileName
+	}
+	if m.FileSize != nil {
+		msg.FileSize = *m.FileSize
+	}
+	if m.Width != nil && m.Height != nil {
+		msg.Width = *m.Width
+		msg.Height = *m.Height
+	}
+	msg.Thumbnails = m.Thumbnails
+
+	return msg
 }
 
-func (h *WebSocketHandler) readPump(client *ws.Client, roomID uuid.UUID) {
+// readPump handles inbound frames. defaultRoomID is used for frames without
+// a room_id, which keeps legacy single-room clients working.
+func (h *WebSocketHandler) readPump(client *ws.Client, defaultRoomID uuid.UUID) {
 	defer func() {
 		h.hub.Unregister <- client
 		client.Conn.Close()
@@ -133,62 +263,75 @@ func (h *WebSocketHandler) readPump(client *ws.Client, roomID uuid.UUID) {
 			break
 		}
 
+		h.hub.Touch(client)
+
 		var msg ws.Message
 		if err := json.Unmarshal(messageBytes, &msg); err != nil {
 			log.Printf("error unmarshaling message: %v", err)
 			continue
 		}
 
-		msg.SenderID = client.ID
+		msg.SenderID = client.UserID
 		msg.Username = client.Username
-		msg.RoomID = roomID
 		msg.Timestamp = time.Now()
 
+		if msg.RoomID == uuid.Nil {
+			msg.RoomID = defaultRoomID
+		}
+		if msg.RoomID == uuid.Nil {
+			h.hub.SendTo(client, ws.Error{Type: "error", Content: "room_id is required"})
+			continue
+		}
+
+		switch msg.Type {
+		case "subscribe":
+			h.subscribe(client, msg.RoomID, msg.LastSeq)
+			continue
+
+		case "unsubscribe":
+			h.hub.LeaveRoom(client, msg.RoomID)
+			continue
+		}
+
+		// Everything else may only target rooms the client subscribed to
+		if !h.hub.IsSubscribed(client, msg.RoomID) {
+			h.hub.SendTo(client, ws.Error{Type: "error", RoomID: msg.RoomID, Content: "Not subscribed to this room"})
+			continue
+		}
+
 		// Handle different message types
 		switch msg.Type {
-		case "message", "file":
-			// Validate message content
-			if msg.Type == "message" && len(msg.Content) == 0 {
-				log.Printf("Empty message rejected from user %s", client.Username)
-				continue
-			}
-			if len(msg.Content) > 10000 { // Max 10KB content
-				log.Printf("Message too long rejected from user %s", client.Username)
+		case "message", "file", "image":
+			if _, err := persistMessage(h.roomRepo, h.messageRepo, h.attachmentRepo, h.hub, h.previewer, h.roomQuota, &msg); err != nil {
+				log.Printf("Message from user %s rejected: %v", client.Username, err)
+				if errors.Is(err, errNotMember) {
+					h.dropSubscription(client, msg.RoomID)
+				}
+				if errors.Is(err, errInvalidMessage) {
+					h.hub.SendTo(client, ws.Error{Type: "error", RoomID: msg.RoomID, Content: err.Error()})
+				}
 				continue
 			}
 
-			// Save message to database
-			dbMessage := &models.Message{
-				RoomID:   msg.RoomID,
-				SenderID: msg.SenderID,
-				Content:  msg.Content,
-				Type:     msg.Type,
+		case "read":
+			if !h.participates(client, msg.RoomID) {
+				continue
 			}
 
-			if msg.Type == "file" {
-				dbMessage.FileURL = &msg.FileURL
-				dbMessage.FileName = &msg.FileName
-				dbMessage.FileSize = &msg.FileSize
+			// seq is the newest message the client has displayed; 0 means all
+			if err := markRead(h.messageRepo, h.hub, msg.RoomID, client.UserID, client.Username, msg.Seq); err != nil {
+				log.Printf("error marking messages as read: %v", err)
 			}
 
-			if err := h.messageRepo.Create(dbMessage); err != nil {
-				log.Printf("error saving message: %v", err)
+		case "typing":
+			if !h.participates(client, msg.RoomID) {
 				continue
 			}
 
-			msg.Timestamp = dbMessage.CreatedAt
-
-			// Broadcast to all clients in room
-			h.hub.Broadcast <- &msg
-
-			// Mark message as read for sender (they sent it, so they've seen it)
-			h.messageRepo.MarkAsRead(dbMessage.ID, client.ID)
-
-		case "typing":
 			// Handle typing indicator
 			typingIndicator := &ws.TypingIndicator{
-				RoomID:   roomID,
-				UserID:   client.ID,
+				RoomID:   msg.RoomID,
+				UserID:   client.UserID,
 				Username: client.Username,
 				IsTyping: true,
 			}
@@ -202,3 +345,194 @@ func (h *WebSocketHandler) readPump(client *ws.Client, roomID uuid.UUID) {
 		}
 	}
 }
+
+// participates reports whether the client's user may still act in the
+// room. Subscriptions outlive membership until the hub hears about a
+// removal, so it is checked again for every frame.
+func (h *WebSocketHandler) participates(client *ws.Client, roomID uuid.UUID) bool {
+	role, err := h.roomRepo.GetMemberRole(roomID, client.UserID)
+	if err != nil {
+		log.Printf("Error checking membership: %v", err)
+		h.hub.SendTo(client, ws.Error{Type: "error", RoomID: roomID, Content: "Error checking membership"})
+		return false
+	}
+
+	if !allowed(role, permParticipate) {
+		h.dropSubscription(client, roomID)
+		return false
+	}
+	return true
+}
+
+// dropSubscription unsubscribes a client whose user no longer belongs to
+// the room
+func (h *WebSocketHandler) dropSubscription(client *ws.Client, roomID uuid.UUID) {
+	log.Printf("User %s is no longer a member of room %s", client.Username, roomID)
+	h.hub.LeaveRoom(client, roomID)
+	h.hub.SendTo(client, ws.Error{Type: "error", RoomID: roomID, Content: "Not a member of this room"})
+}
+
+var (
+	// errInvalidMessage marks persistMessage failures caused by the sender
+	errInvalidMessage = errors.New("invalid message")
+
+	// errNotMember means the sender doesn't belong to the room
+	errNotMember = errors.New("not a member of this room")
+)
+
+// persistMessage validates msg, stores it and broadcasts it to the room.
+// It is shared by the WebSocket and REST send paths; msg is filled in with
+// the stored ID, timestamp and reply details before it is broadcast. Files
+// count against the room's storage quota, which defaults to roomQuota.
+// Links are previewed afterwards, when previewer is set. The sender must
+// still belong to the room, or errNotMember is returned.
+func persistMessage(roomRepo *repository.RoomRepository, messageRepo *repository.MessageRepository, attachmentRepo *repository.AttachmentRepository, hub *ws.Hub, previewer *LinkPreviewer, roomQuota int64, msg *ws.Message) (*models.Message, error) {
+	role, err := roomRepo.GetMemberRole(msg.RoomID, msg.SenderID)
+	if err != nil {
+		return nil, fmt.Errorf("error checking membership: %w", err)
+	}
+	if !allowed(role, permParticipate) {
+		return nil, errNotMember
+	}
+
+	// Validate message content
+	if msg.Type == "message" && len(msg.Content) == 0 {
+		return nil, fmt.Errorf("%w: content is required", errInvalidMessage)
+	}
+	if len(msg.Content) > 10000 { // Max 10KB content
+		return nil, fmt.Errorf("%w: content is too long", errInvalidMessage)
+	}
+
+	// Plain chat messages are stored as "text" to satisfy the schema
+	dbType := msg.Type
+	if dbType == "message" {
+		dbType = "text"
+	}
+
+	dbMessage := &models.Message{
+		RoomID:   msg.RoomID,
+		SenderID: msg.SenderID,
+		Content:  msg.Content,
+		Type:     dbType,
+	}
+
+	// Files must be the sender's own uploads; sending one gives the room
+	// access to it
+	var attachment *models.Attachment
+	if msg.Type == "file" || msg.Type == "image" {
+		if msg.FileURL == "" {
+			return nil, fmt.Errorf("%w: file_url is required", errInvalidMessage)
+		}
+
+		attachmentID, ok := attachmentIDFromURL(msg.FileURL)
+		if !ok {
+			return nil, fmt.Errorf("%w: file_url must be an uploaded attachment", errInvalidMessage)
+		}
+
+		attachment, err = attachmentRepo.AttachToRoom(attachmentID, msg.SenderID, msg.RoomID, roomQuota)
+		if errors.Is(err, repository.ErrAttachmentNotFound) {
+			return nil, fmt.Errorf("%w: attachment not found", errInvalidMessage)
+		}
+		if errors.Is(err, repository.ErrQuotaExceeded) {
+			return nil, fmt.Errorf("%w: the room's storage quota is full", errInvalidMessage)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error attaching file: %w", err)
+		}
+
+		// Images are told apart by content, whatever the client called them
+		msg.Type = "file"
+		if attachment.Width != nil {
+			msg.Type = "image"
+		}
+		dbMessage.Type = msg.Type
+
+		msg.FileURL = models.AttachmentURL(attachment.ID)
+		msg.FileName = attachment.FileName
+		msg.FileSize = attachment.Size
+		dbMessage.FileURL = &msg.FileURL
+		dbMessage.FileName = &msg.FileName
+		dbMessage.FileSize = &msg.FileSize
+
+		dbMessage.Width = attachment.Width
+		dbMessage.Height = attachment.Height
+		dbMessage.Thumbnails = attachment.Thumbnails
+	}
+
+	// Only the attachment decides dimensions and thumbnails
+	msg.Width, msg.Height, msg.Thumbnails = 0, 0, dbMessage.Thumbnails
+	if dbMessage.Width != nil && dbMessage.Height != nil {
+		msg.Width, msg.Height = *dbMessage.Width, *dbMessage.Height
+	}
+
+	// Replies join the parent's thread, or start one rooted at the parent
+	if msg.ReplyToID != nil {
+		parent, err := messageRepo.FindByID(*msg.ReplyToID, msg.SenderID)
+		if err != nil || parent.RoomID != msg.RoomID {
+			return nil, fmt.Errorf("%w: reply target not found in this room", errInvalidMessage)
+		}
+
+		rootID := parent.ID
+		if parent.ThreadRootID != nil {
+			rootID = *parent.ThreadRootID
+		}
+
+		dbMessage.ReplyToID = &parent.ID
+		dbMessage.ThreadRootID = &rootID
+		dbMessage.ReplyTo = parent.Preview()
+	}
+
+	if err := messageRepo.Create(dbMessage); err != nil {
+		return nil, fmt.Errorf("error saving message: %w", err)
+	}
+
+	if attachment != nil {
+		if err := attachmentRepo.SetMessage(attachment.ID, dbMessage.ID); err != nil {
+			log.Printf("error linking attachment %s to message: %v", attachment.ID, err)
+		}
+	}
+
+	msg.ID = dbMessage.ID
+	msg.Seq = dbMessage.Seq
+	msg.LastSeq = 0
+	msg.Replay = false
+	msg.Timestamp = dbMessage.CreatedAt
+	msg.ReplyToID = dbMessage.ReplyToID
+	msg.ThreadRootID = dbMessage.ThreadRootID
+	msg.ReplyTo = dbMessage.ReplyTo
+
+	// Broadcast to all clients in room
+	hub.Broadcast <- msg
+
+	if previewer != nil {
+		previewer.Enqueue(dbMessage)
+	}
+
+	// Mark message as read for sender (they sent it, so they've seen it)
+	messageRepo.MarkAsRead(dbMessage.ID, msg.SenderID)
+
+	return dbMessage, nil
+}
+
+// markRead records that userID read the room up to upToSeq (0 means every
+// message) and tells the room, so senders can show who has seen what
+func markRead(messageRepo *repository.MessageRepository, hub *ws.Hub, roomID, userID uuid.UUID, username string, upToSeq int64) error {
+	marker, changed, err := messageRepo.MarkRoomMessagesAsRead(roomID, userID, upToSeq)
+	if err != nil {
+		return err
+	}
+
+	if marker != nil && changed {
+		hub.BroadcastToRoom(roomID, ws.ReadEvent{
+			Type:      "read",
+			RoomID:    roomID,
+			UserID:    userID,
+			Username:  username,
+			MessageID: marker.MessageID,
+			Seq:       marker.Seq,
+			ReadAt:    time.Now(),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package imaging
+
+import "encoding/binary"
+
+// MaxFrames bounds the frames of an animation. Every frame costs a palette
+// and bookkeeping on top of its pixels, however small it is.
+const MaxFrames = 1000
+
+// gifFrames walks a GIF's blocks without decoding them and returns how many
+// frames it has and how many pixels they add up to, so an animation can be
+// rejected before it is decoded. It stops early at anything malformed,
+// which the decoder rejects anyway.
+func gifFrames(data []byte) (frames int, pixels int, ok bool) {
+	// Header, then the logical screen descriptor
+	if len(data) < 13 {
+		return 0, 0, false
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1) // Global color table
+	}
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // Extension: label, then data sub-blocks
+			pos = skipSubBlocks(data, pos+2)
+
+		case 0x2C: // Image descriptor
+			if pos+10 > len(data) {
+				return frames, pixels, true
+			}
+			width := int(binary.LittleEndian.Uint16(data[pos+5:]))
+			height := int(binary.LittleEndian.Uint16(data[pos+7:]))
+			flags := data[pos+9]
+
+			frames++
+			pixels += width * height
+			if frames > MaxFrames || pixels > MaxPixels {
+				return frames, pixels, true
+			}
+
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1) // Local color table
+			}
+			// LZW minimum code size, then the image data
+			pos = skipSubBlocks(data, pos+1)
+
+		default: // Trailer, or garbage the decoder will refuse
+			return frames, pixels, true
+		}
+	}
+
+	return frames, pixels, true
+}
+
+// skipSubBlocks returns the position after the sub-blocks starting at pos
+func skipSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			return pos
+		}
+		pos += size
+	}
+	return len(data)
+}
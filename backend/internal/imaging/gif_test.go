@@ -0,0 +1,187 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+// gifHeader returns a GIF89a header and logical screen descriptor, with a
+// two-color global color table if global is set
+func gifHeader(width, height uint16, global bool) []byte {
+	data := []byte("GIF89a")
+	data = binary.LittleEndian.AppendUint16(data, width)
+	data = binary.LittleEndian.AppendUint16(data, height)
+	if global {
+		data = append(data, 0x80, 0, 0)
+		data = append(data, 0, 0, 0, 0xFF, 0xFF, 0xFF)
+	} else {
+		data = append(data, 0, 0, 0)
+	}
+	return data
+}
+
+// gifFrame returns a graphic control extension and an image descriptor
+// with a single sub-block of image data. A local color table of
+// localColors entries filled with fill is added if localColors is set.
+func gifFrame(width, height uint16, localColors int, fill byte) []byte {
+	data := []byte{0x21, 0xF9, 0x04, 0, 0, 0, 0, 0}
+	data = append(data, 0x2C, 0, 0, 0, 0)
+	data = binary.LittleEndian.AppendUint16(data, width)
+	data = binary.LittleEndian.AppendUint16(data, height)
+	if localColors == 0 {
+		data = append(data, 0)
+	} else {
+		size := byte(0)
+		for 2<<size < localColors {
+			size++
+		}
+		data = append(data, 0x80|size)
+		data = append(data, bytes.Repeat([]byte{fill}, 3*(2<<size))...)
+	}
+	return append(data, 0x02, 0x01, 0x44, 0x00)
+}
+
+// gifOf joins a header, frames and the trailer
+func gifOf(header []byte, frames ...[]byte) []byte {
+	data := append([]byte{}, header...)
+	for _, frame := range frames {
+		data = append(data, frame...)
+	}
+	return append(data, 0x3B)
+}
+
+func repeatFrame(frame []byte, n int) [][]byte {
+	frames := make([][]byte, n)
+	for i := range frames {
+		frames[i] = frame
+	}
+	return frames
+}
+
+func TestGIFFrames(t *testing.T) {
+	header := gifHeader(10, 10, true)
+	full := gifOf(header, gifFrame(10, 10, 0, 0))
+
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int
+		pixels int
+		ok     bool
+	}{
+		{
+			name:   "single frame",
+			data:   full,
+			frames: 1,
+			pixels: 100,
+			ok:     true,
+		},
+		{
+			name:   "no global color table",
+			data:   gifOf(gifHeader(4, 5, false), gifFrame(4, 5, 0, 0), gifFrame(2, 2, 0, 0)),
+			frames: 2,
+			pixels: 24,
+			ok:     true,
+		},
+		{
+			// Filled with descriptor bytes, so reading the table as blocks
+			// would count frames that aren't there
+			name:   "local color table",
+			data:   gifOf(header, gifFrame(10, 10, 256, 0x2C), gifFrame(3, 3, 2, 0x2C)),
+			frames: 2,
+			pixels: 109,
+			ok:     true,
+		},
+		{
+			name:   "frame count bomb",
+			data:   gifOf(header, repeatFrame(gifFrame(1, 1, 0, 0), MaxFrames+50)...),
+			frames: MaxFrames + 1,
+			pixels: MaxFrames + 1,
+			ok:     true,
+		},
+		{
+			// Each frame is within the limit on its own
+			name:   "pixel sum bomb",
+			data:   gifOf(header, repeatFrame(gifFrame(5000, 5000, 0, 0), 3)...),
+			frames: 2,
+			pixels: 50_000_000,
+			ok:     true,
+		},
+		{
+			name:   "truncated descriptor",
+			data:   append(gifOf(header, gifFrame(10, 10, 0, 0))[:len(full)-1], 0x2C, 0, 0, 0, 0, 10),
+			frames: 1,
+			pixels: 100,
+			ok:     true,
+		},
+		{
+			name:   "truncated local color table",
+			data:   gifOf(header, gifFrame(10, 10, 256, 0))[:len(header)+30],
+			frames: 1,
+			pixels: 100,
+			ok:     true,
+		},
+		{
+			name: "truncated header",
+			data: full[:12],
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, pixels, ok := gifFrames(tt.data)
+			if frames != tt.frames || pixels != tt.pixels || ok != tt.ok {
+				t.Errorf("gifFrames() = %d, %d, %v, want %d, %d, %v",
+					frames, pixels, ok, tt.frames, tt.pixels, tt.ok)
+			}
+		})
+	}
+}
+
+// The encoder writes a local color table for frames whose palette differs
+// from the global one
+func TestGIFFramesMatchesEncoder(t *testing.T) {
+	global := color.Palette{color.Black, color.White}
+	local := color.Palette{color.Black, color.White, color.RGBA{R: 0xFF, A: 0xFF}, color.RGBA{G: 0xFF, A: 0xFF}}
+
+	animation := &gif.GIF{
+		Image: []*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 8, 6), global),
+			image.NewPaletted(image.Rect(0, 0, 4, 4), local),
+			image.NewPaletted(image.Rect(2, 2, 5, 5), global),
+		},
+		Delay:  []int{0, 0, 0},
+		Config: image.Config{ColorModel: global, Width: 8, Height: 6},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, animation); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, pixels, ok := gifFrames(buf.Bytes())
+	if frames != 3 || pixels != 48+16+9 || !ok {
+		t.Errorf("gifFrames() = %d, %d, %v, want 3, 73, true", frames, pixels, ok)
+	}
+}
+
+func TestProcessRejectsGIFBombs(t *testing.T) {
+	header := gifHeader(1, 1, true)
+	tests := map[string][]byte{
+		"frame count": gifOf(header, repeatFrame(gifFrame(1, 1, 0, 0), MaxFrames+1)...),
+		"pixel sum":   gifOf(header, repeatFrame(gifFrame(5000, 5000, 0, 0), 2)...),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Process(data); !errors.Is(err, ErrTooLarge) {
+				t.Errorf("Process() error = %v, want %v", err, ErrTooLarge)
+			}
+		})
+	}
+}
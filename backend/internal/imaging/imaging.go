@@ -0,0 +1,257 @@
+// Package imaging prepares uploaded images for storage: it records their
+// dimensions, re-encodes them without EXIF, GPS or other embedded metadata
+// and generates thumbnails. Only formats the standard library can decode
+// are processed; anything else is stored as a plain file.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	// MaxPixels bounds the decoded size, so a small file can't expand into
+	// gigabytes of pixels
+	MaxPixels = 25_000_000
+
+	// MaxFileSize bounds the images read into memory to be processed
+	MaxFileSize = 50 << 20
+
+	jpegQuality          = 90
+	thumbnailJPEGQuality = 80
+)
+
+// ThumbnailSizes are the longest sides thumbnails are scaled to fit
+var ThumbnailSizes = []int{160, 480, 1080}
+
+var (
+	// ErrUnsupported means the data isn't an image format we can decode
+	ErrUnsupported = errors.New("unsupported image format")
+
+	// ErrTooLarge means the image has more than MaxPixels pixels, or an
+	// animation more than MaxFrames frames or MaxPixels across them all
+	ErrTooLarge = errors.New("image dimensions are too large")
+)
+
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+// Supported reports whether contentType is an image format Process handles
+func Supported(contentType string) bool {
+	for _, supported := range contentTypes {
+		if contentType == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// Image is a processed image, ready to store
+type Image struct {
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte // Re-encoded without metadata
+
+	// first is the image, or the first frame of an animation, as displayed
+	first *image.RGBA
+}
+
+// Thumbnail is a downscaled, encoded copy of an image
+type Thumbnail struct {
+	Size        int
+	ContentType string
+	Width       int
+	Height      int
+	Data        []byte
+}
+
+// Process decodes an image, applies its EXIF orientation and re-encodes it
+// without metadata
+func Process(data []byte) (*Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrUnsupported
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	contentType, ok := contentTypes[format]
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	result := &Image{ContentType: contentType}
+	var buf bytes.Buffer
+
+	switch format {
+	case "jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding jpeg: %w", err)
+		}
+
+		// Re-encoding drops the EXIF block, so bake its rotation into the
+		// pixels or phone photos would come out sideways
+		result.first = orient(toRGBA(img), jpegOrientation(data))
+		if err := jpeg.Encode(&buf, result.first, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+
+	case "png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding png: %w", err)
+		}
+
+		// The encoder writes pixel data only, leaving out text and eXIf chunks
+		result.first = toRGBA(img)
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+
+	case "gif":
+		// Every frame is decoded, so the limit applies to all of them
+		frames, pixels, ok := gifFrames(data)
+		if !ok {
+			return nil, ErrUnsupported
+		}
+		if frames > MaxFrames || pixels > MaxPixels {
+			return nil, ErrTooLarge
+		}
+
+		animation, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error decoding gif: %w", err)
+		}
+		if len(animation.Image) == 0 {
+			return nil, ErrUnsupported
+		}
+
+		// Comment and application extensions are not written back
+		canvas := image.NewRGBA(image.Rect(0, 0, animation.Config.Width, animation.Config.Height))
+		draw.Draw(canvas, animation.Image[0].Bounds(), animation.Image[0], animation.Image[0].Bounds().Min, draw.Over)
+		result.first = canvas
+		if err := gif.EncodeAll(&buf, animation); err != nil {
+			return nil, err
+		}
+	}
+
+	bounds := result.first.Bounds()
+	result.Width = bounds.Dx()
+	result.Height = bounds.Dy()
+	result.Data = buf.Bytes()
+	return result, nil
+}
+
+// Thumbnail scales the image to fit within size×size. It returns false if
+// the image already fits, since a thumbnail would be no smaller.
+func (i *Image) Thumbnail(size int) (*Thumbnail, bool, error) {
+	if i.Width <= size && i.Height <= size {
+		return nil, false, nil
+	}
+
+	width, height := Fit(i.Width, i.Height, size)
+	scaled := resize(i.first, width, height)
+
+	thumbnail := &Thumbnail{
+		Size:        size,
+		ContentType: ThumbnailContentType(i.ContentType),
+		Width:       width,
+		Height:      height,
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if thumbnail.ContentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	} else {
+		err = png.Encode(&buf, scaled)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	thumbnail.Data = buf.Bytes()
+	return thumbnail, true, nil
+}
+
+// Fit scales width×height down to fit within size×size, keeping the
+// aspect ratio
+func Fit(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, max(1, height*size/width)
+	}
+	return max(1, width*size/height), size
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// resize scales src down to width×height, averaging every source pixel
+// that falls within each destination pixel
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max(y0+1, (y+1)*srcHeight/height)
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max(x0+1, (x+1)*srcWidth/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				offset := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(src.Pix[offset])
+					g += uint64(src.Pix[offset+1])
+					b += uint64(src.Pix[offset+2])
+					a += uint64(src.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / n)
+			dst.Pix[offset+1] = uint8(g / n)
+			dst.Pix[offset+2] = uint8(b / n)
+			dst.Pix[offset+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// ThumbnailContentType is the format thumbnails of contentType images are
+// encoded in. Photos stay JPEG; PNG and GIF may be transparent, so their
+// thumbnails stay lossless.
+func ThumbnailContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
@@ -0,0 +1,198 @@
+// Package linkpreview fetches the OpenGraph and Twitter card metadata of
+// pages linked from messages. The server makes these requests on behalf of
+// users, so they are confined to public addresses and bounded in time and
+// size.
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/halizadz/chat-app-backend/internal/models"
+)
+
+const (
+	// Timeout bounds a whole fetch, redirects included
+	Timeout = 5 * time.Second
+
+	// MaxBodySize is how much of a page is read looking for metadata
+	MaxBodySize = 512 << 10
+
+	maxRedirects = 3
+	userAgent    = "ChatAppBot/1.0 (link preview)"
+)
+
+var (
+	// ErrBlockedAddress means the link leads to a private, loopback or
+	// otherwise internal address
+	ErrBlockedAddress = errors.New("address is not publicly routable")
+
+	// ErrNoPreview means the page has no usable metadata
+	ErrNoPreview = errors.New("page has no preview")
+)
+
+// Fetcher fetches link previews
+type Fetcher struct {
+	client *http.Client
+}
+
+func NewFetcher() *Fetcher {
+	// The check runs on the address actually dialed, after DNS resolution
+	// and for every redirect, so rebinding a name to an internal address
+	// doesn't get around it
+	dialer := &net.Dialer{
+		Timeout: Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkAddress(address)
+		},
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil, // A proxy would do the dialing instead
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   Timeout,
+		ResponseHeaderTimeout: Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				return checkURL(req.URL)
+			},
+		},
+	}
+}
+
+// Fetch loads the page at rawURL and returns its preview, or ErrNoPreview
+// if it isn't an HTML page with a title
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*models.LinkPreview, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkURL(target); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNoPreview
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	// Relative image URLs resolve against the page, wherever redirects led
+	preview := parseMetadata(string(body), resp.Request.URL)
+	if preview.Title == "" {
+		return nil, ErrNoPreview
+	}
+	preview.URL = rawURL
+	return preview, nil
+}
+
+// checkURL allows http and https URLs on their default ports
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return errors.New("url has no host")
+	}
+	if u.User != nil {
+		return errors.New("url has credentials")
+	}
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		return fmt.Errorf("port %s is not allowed", port)
+	}
+	return nil
+}
+
+// Ranges that IsPrivate and friends don't cover but that aren't reachable
+// on the public internet either
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",       // "This" network
+	"100.64.0.0/10",   // Carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // Documentation
+	"198.18.0.0/15",   // Benchmarking
+	"198.51.100.0/24", // Documentation
+	"203.0.113.0/24",  // Documentation
+	"240.0.0.0/4",     // Reserved
+	"64:ff9b::/96",    // NAT64, which can reach private IPv4 addresses
+	"64:ff9b:1::/48",  // Local-use NAT64
+	"2001:db8::/32",   // Documentation
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// checkAddress rejects dialing anything but public unicast addresses
+func checkAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrBlockedAddress
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return ErrBlockedAddress
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return ErrBlockedAddress
+		}
+	}
+	return nil
+}
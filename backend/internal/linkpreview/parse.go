@@ -0,0 +1,114 @@
+package linkpreview
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/halizadz/chat-app-backend/internal/models"
+)
+
+const (
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+	maxURLLength         = 2048
+)
+
+var (
+	urlPattern        = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+	metaTagPattern    = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern  = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titleTagPattern   = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	headEndPattern    = regexp.MustCompile(`(?i)</head\s*>`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+)
+
+// FindURL returns the first http or https link in a message, if any
+func FindURL(content string) (string, bool) {
+	for _, match := range urlPattern.FindAllString(content, -1) {
+		// Punctuation ending a sentence isn't part of the link
+		match = strings.TrimRight(match, ".,;:!?)]}")
+		if len(match) > maxURLLength {
+			continue
+		}
+
+		u, err := url.Parse(match)
+		if err != nil || checkURL(u) != nil {
+			continue
+		}
+		return match, true
+	}
+	return "", false
+}
+
+// parseMetadata reads a preview from the page's OpenGraph and Twitter card
+// tags, falling back to its <title> and description
+func parseMetadata(page string, base *url.URL) *models.LinkPreview {
+	// Metadata belongs in the head; don't scan the body for lookalikes
+	if loc := headEndPattern.FindStringIndex(page); loc != nil {
+		page = page[:loc[0]]
+	}
+
+	meta := make(map[string]string)
+	for _, tag := range metaTagPattern.FindAllString(page, -1) {
+		var key, content string
+		for _, attr := range attributePattern.FindAllStringSubmatch(tag, -1) {
+			value := attr[2] + attr[3] + attr[4]
+			switch strings.ToLower(attr[1]) {
+			case "property", "name":
+				key = strings.ToLower(value)
+			case "content":
+				content = value
+			}
+		}
+		// The first occurrence of a tag wins
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = clean(content)
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value := meta[key]; value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	preview := &models.LinkPreview{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		SiteName:    first("og:site_name"),
+	}
+	if preview.Title == "" {
+		if match := titleTagPattern.FindStringSubmatch(page); match != nil {
+			preview.Title = clean(match[1])
+		}
+	}
+	preview.Title = truncate(preview.Title, maxTitleLength)
+	preview.Description = truncate(preview.Description, maxDescriptionLength)
+	preview.SiteName = truncate(preview.SiteName, maxTitleLength)
+
+	if image := first("og:image:secure_url", "og:image", "og:image:url", "twitter:image", "twitter:image:src"); image != "" {
+		if u, err := base.Parse(image); err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.String()) <= maxURLLength {
+			preview.ImageURL = u.String()
+		}
+	}
+
+	return preview
+}
+
+// clean unescapes HTML entities and collapses whitespace
+func clean(s string) string {
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(html.UnescapeString(s), " "))
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:n])) + "…"
+}
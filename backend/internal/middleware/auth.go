@@ -2,9 +2,11 @@ package middleware
 
 import (
     "context"
+    "fmt"
     "net/http"
     "strings"
 
+    "github.com/google/uuid"
     "github.com/halizadz/chat-app-backend/internal/utils"
 )
 
@@ -12,7 +14,35 @@ type contextKey string
 
 const UserContextKey contextKey = "user"
 
-func AuthMiddleware(secret string) func(http.Handler) http.Handler {
+// SessionChecker reports whether the session behind a token is still live
+type SessionChecker interface {
+    IsActive(sessionID uuid.UUID) (bool, error)
+}
+
+// Authenticate validates an access token and rejects it if its session was
+// revoked, even though the token itself has not expired yet
+func Authenticate(tokenString, secret string, sessions SessionChecker) (*utils.Claims, error) {
+    claims, err := utils.ValidateToken(tokenString, secret)
+    if err != nil {
+        return nil, err
+    }
+
+    if claims.SessionID == uuid.Nil {
+        return nil, fmt.Errorf("token has no session")
+    }
+
+    active, err := sessions.IsActive(claims.SessionID)
+    if err != nil {
+        return nil, fmt.Errorf("error checking session: %w", err)
+    }
+    if !active {
+        return nil, fmt.Errorf("session revoked")
+    }
+
+    return claims, nil
+}
+
+func AuthMiddleware(secret string, sessions SessionChecker) func(http.Handler) http.Handler {
     return func(next http.Handler) http.Handler {
         return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
             // Skip auth for OPTIONS requests (preflight)
@@ -33,7 +63,7 @@ func AuthMiddleware(secret string) func(http.Handler) http.Handler {
                 return
             }
 
-            claims, err := utils.ValidateToken(bearerToken[1], secret)
+            claims, err := Authenticate(bearerToken[1], secret, sessions)
             if err != nil {
                 http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
                 return
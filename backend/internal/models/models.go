@@ -1,6 +1,9 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,37 +25,328 @@ type Room struct {
 	ID          uuid.UUID `json:"id"`
 	Name        string    `json:"name"`
 	Description *string   `json:"description"`
-	Type        string    `json:"type"` // private, group
+	Type        string    `json:"type"`       // private, group
+	Visibility  string    `json:"visibility"` // public, private; only groups can be public
 	CreatedBy   uuid.UUID `json:"created_by"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// RoomSummary is a room as listed in a user's sidebar
+type RoomSummary struct {
+	Room
+	UnreadCount    int       `json:"unread_count"`
+	LastMessage    *Message  `json:"last_message"`
+	LastActivityAt time.Time `json:"last_activity_at"`
+}
+
 type Message struct {
-	ID        uuid.UUID   `json:"id"`
-	RoomID    uuid.UUID   `json:"room_id"`
-	SenderID  uuid.UUID   `json:"sender_id"`
-	Content   string      `json:"content"`
-	Type      string      `json:"type"`
-	FileURL   *string     `json:"file_url,omitempty"`
-	FileName  *string     `json:"file_name,omitempty"`
-	FileSize  *int64      `json:"file_size,omitempty"`
-	CreatedAt time.Time   `json:"created_at"`
-	UpdatedAt time.Time   `json:"updated_at"`
-	IsEdited  bool        `json:"is_edited"`
-	IsDeleted bool        `json:"is_deleted"`
-	Sender    *User       `json:"sender,omitempty"`
-	ReadBy    []uuid.UUID `json:"read_by,omitempty"` // Users who read this message
+	ID           uuid.UUID       `json:"id"`
+	RoomID       uuid.UUID       `json:"room_id"`
+	SenderID     uuid.UUID       `json:"sender_id"`
+	Content      string          `json:"content"`
+	Type         string          `json:"type"`
+	FileURL      *string         `json:"file_url,omitempty"`
+	FileName     *string         `json:"file_name,omitempty"`
+	FileSize     *int64          `json:"file_size,omitempty"`
+	Width        *int            `json:"width,omitempty"` // Set for images
+	Height       *int            `json:"height,omitempty"`
+	Thumbnails   Thumbnails      `json:"thumbnails,omitempty"`
+	LinkPreview  *LinkPreview    `json:"link_preview,omitempty"` // Added shortly after sending
+	ReplyToID    *uuid.UUID      `json:"reply_to_id,omitempty"`
+	ThreadRootID *uuid.UUID      `json:"thread_root_id,omitempty"`
+	ReplyTo      *MessagePreview `json:"reply_to,omitempty"`    // Compact copy of the parent message
+	ReplyCount   int             `json:"reply_count,omitempty"` // Replies in the thread this message starts
+	Seq          int64           `json:"seq"`                   // Position in the room, increasing by one per message
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	IsEdited     bool            `json:"is_edited"`
+	IsDeleted    bool            `json:"is_deleted"`
+	Sender       *User           `json:"sender,omitempty"`
+	ReadBy       []uuid.UUID     `json:"read_by,omitempty"` // Users who read this message
+	Reactions    []Reaction      `json:"reactions,omitempty"`
+}
+
+// Reaction is the aggregate of every user who reacted with one emoji
+type Reaction struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+// MessagePreview is a short summary of a message shown alongside replies
+type MessagePreview struct {
+	ID             uuid.UUID `json:"id"`
+	SenderID       uuid.UUID `json:"sender_id"`
+	SenderUsername string    `json:"sender_username"`
+	Content        string    `json:"content"`
+	Type           string    `json:"type"`
+	IsDeleted      bool      `json:"is_deleted"`
+}
+
+// Preview summarizes the message for display next to a reply
+func (m *Message) Preview() *MessagePreview {
+	content := []rune(m.Content)
+	if len(content) > 200 {
+		content = content[:200]
+	}
+
+	preview := &MessagePreview{
+		ID:        m.ID,
+		SenderID:  m.SenderID,
+		Content:   string(content),
+		Type:      m.Type,
+		IsDeleted: m.IsDeleted,
+	}
+	if m.Sender != nil {
+		preview.SenderUsername = m.Sender.Username
+	}
+	return preview
 }
 
 type RoomMember struct {
 	ID       uuid.UUID `json:"id"`
 	RoomID   uuid.UUID `json:"room_id"`
 	UserID   uuid.UUID `json:"user_id"`
-	Role     string    `json:"role"` // admin, member
+	Role     string    `json:"role"` // owner, admin, moderator, member
 	JoinedAt time.Time `json:"joined_at"`
 }
 
+// Room roles, from most to least privileged
+const (
+	RoleOwner     = "owner"
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// RoleRank orders roles by privilege; unknown roles rank below members
+func RoleRank(role string) int {
+	switch role {
+	case RoleOwner:
+		return 4
+	case RoleAdmin:
+		return 3
+	case RoleModerator:
+		return 2
+	case RoleMember:
+		return 1
+	}
+	return 0
+}
+
+// RoomFile is a file or image sent in a room, as listed in its gallery
+type RoomFile struct {
+	MessageID    uuid.UUID  `json:"message_id"`
+	AttachmentID *uuid.UUID `json:"attachment_id,omitempty"`
+	Category     string     `json:"category"` // image, document or other
+	FileURL      string     `json:"file_url"`
+	FileName     string     `json:"file_name"`
+	FileSize     int64      `json:"file_size"`
+	ContentType  string     `json:"content_type,omitempty"`
+	Width        *int       `json:"width,omitempty"`
+	Height       *int       `json:"height,omitempty"`
+	Thumbnails   Thumbnails `json:"thumbnails,omitempty"`
+	Sender       *User      `json:"sender"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// SearchResult is a message matched by full-text search, with the room
+// it was posted in and an excerpt highlighting the matched terms
+type SearchResult struct {
+	Message  *Message `json:"message"`
+	RoomName string   `json:"room_name"`
+	RoomType string   `json:"room_type"`
+	Snippet  string   `json:"snippet"` // HTML-escaped, matches wrapped in <mark>
+	Rank     float64  `json:"rank"`
+}
+
+// PublicRoom is a public group room as listed in room discovery
+type PublicRoom struct {
+	Room
+	MemberCount int  `json:"member_count"`
+	IsMember    bool `json:"is_member"`
+}
+
+// RoomBan blocks a user from joining a room
+type RoomBan struct {
+	RoomID    uuid.UUID  `json:"room_id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	Username  string     `json:"username"`
+	BannedBy  *uuid.UUID `json:"banned_by,omitempty"`
+	Reason    *string    `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RoomInvitation asks a user to join a group room
+type RoomInvitation struct {
+	ID              uuid.UUID  `json:"id"`
+	RoomID          uuid.UUID  `json:"room_id"`
+	RoomName        string     `json:"room_name"`
+	InviterID       uuid.UUID  `json:"inviter_id"`
+	InviterUsername string     `json:"inviter_username"`
+	InviteeID       uuid.UUID  `json:"invitee_id"`
+	Status          string     `json:"status"` // pending, accepted, declined, cancelled
+	CreatedAt       time.Time  `json:"created_at"`
+	RespondedAt     *time.Time `json:"responded_at,omitempty"`
+}
+
+// InviteLink lets anyone holding its code join a group room, until it
+// expires, runs out of uses or is revoked
+type InviteLink struct {
+	ID        uuid.UUID  `json:"id"`
+	RoomID    uuid.UUID  `json:"room_id"`
+	Code      string     `json:"code"`
+	CreatedBy uuid.UUID  `json:"created_by"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   *int       `json:"max_uses,omitempty"`
+	UseCount  int        `json:"use_count"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Member is a room member's profile along with their role in the room
+type Member struct {
+	User
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Session is one login; its refresh token is stored only as a hash
+type Session struct {
+	ID         uuid.UUID  `json:"id"`
+	UserID     uuid.UUID  `json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	Current    bool       `json:"current"` // The session making the request
+}
+
+// Attachment is an uploaded file. It belongs to its uploader until it is
+// sent, and to the room it was sent in afterwards.
+type Attachment struct {
+	ID          uuid.UUID  `json:"id"`
+	UploaderID  uuid.UUID  `json:"uploader_id"`
+	RoomID      *uuid.UUID `json:"room_id,omitempty"`
+	MessageID   *uuid.UUID `json:"message_id,omitempty"`
+	StorageKey  string     `json:"-"`
+	FileName    string     `json:"file_name"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	Width       *int       `json:"width,omitempty"` // Set for images
+	Height      *int       `json:"height,omitempty"`
+	Thumbnails  Thumbnails `json:"thumbnails,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Upload is a chunked upload in progress. Chunks must arrive in order, so
+// Received is also the offset the next chunk starts at.
+type Upload struct {
+	ID           uuid.UUID  `json:"id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	FileName     string     `json:"file_name"`
+	Size         int64      `json:"size"`
+	Received     int64      `json:"offset"`
+	Checksum     *string    `json:"checksum,omitempty"` // Expected SHA-256, hex encoded
+	HashState    []byte     `json:"-"`                  // SHA-256 of the bytes received so far
+	Status       string     `json:"status"`             // pending, processing, completed
+	AttachmentID *uuid.UUID `json:"attachment_id,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+}
+
+// UploadChunk is one stored piece of an upload
+type UploadChunk struct {
+	Position   int64
+	Size       int64
+	StorageKey string
+}
+
+// QuarantinedFile is an upload the malware scanner flagged. It is kept for
+// review but never becomes an attachment.
+type QuarantinedFile struct {
+	ID          uuid.UUID `json:"id"`
+	UploaderID  uuid.UUID `json:"uploader_id"`
+	StorageKey  string    `json:"-"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Signature   string    `json:"signature"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// StorageUsage is how much of their storage quota a user's uploads take up
+type StorageUsage struct {
+	Used        int64  `json:"used"`
+	Quota       *int64 `json:"quota"` // nil when unlimited
+	Remaining   *int64 `json:"remaining"`
+	Attachments int    `json:"attachments"`
+}
+
+// AttachmentURLPrefix starts the authenticated download path of every
+// attachment
+const AttachmentURLPrefix = "/api/attachments/"
+
+// AttachmentURL is the authenticated download path of an attachment; it is
+// what messages store as their file_url
+func AttachmentURL(id uuid.UUID) string {
+	return AttachmentURLPrefix + id.String()
+}
+
+// Thumbnail is a downscaled copy of an image attachment
+type Thumbnail struct {
+	Size   int    `json:"size"` // Longest side it was scaled to fit
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+// ThumbnailURL is the authenticated download path of a thumbnail
+func ThumbnailURL(attachmentID uuid.UUID, size int) string {
+	return fmt.Sprintf("%s/thumbnails/%d", AttachmentURL(attachmentID), size)
+}
+
+// Thumbnails is stored as a JSON column
+type Thumbnails []Thumbnail
+
+func (t Thumbnails) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	// A string, since lib/pq would send []byte as bytea
+	return string(data), nil
+}
+
+func (t *Thumbnails) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	default:
+		return fmt.Errorf("cannot scan %T into Thumbnails", src)
+	}
+}
+
+// Find returns the thumbnail of the given size
+func (t Thumbnails) Find(size int) (Thumbnail, bool) {
+	for _, thumbnail := range t {
+		if thumbnail.Size == size {
+			return thumbnail, true
+		}
+	}
+	return Thumbnail{}, false
+}
+
 // WebSocket Message Types
 type WSMessage struct {
 	Type    string      `json:"type"` // message, typing, join, leave
@@ -65,3 +359,33 @@ type TypingIndicator struct {
 	Username string    `json:"username"`
 	IsTyping bool      `json:"is_typing"`
 }
+
+// LinkPreview summarizes the page a message links to, from its OpenGraph
+// or Twitter card metadata
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+func (p LinkPreview) Value() (driver.Value, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	// A string, since lib/pq would send []byte as bytea
+	return string(data), nil
+}
+
+func (p *LinkPreview) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	default:
+		return fmt.Errorf("cannot scan %T into LinkPreview", src)
+	}
+}
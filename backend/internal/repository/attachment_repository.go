@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/halizadz/chat-app-backend/internal/models"
+)
+
+var (
+	// ErrAttachmentNotFound means no attachment matches, or it can't be
+	// sent by this user to this room
+	ErrAttachmentNotFound = errors.New("attachment not found")
+
+	// ErrQuotaExceeded means a file doesn't fit in the storage quota of its
+	// uploader or of the room it is sent to
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+type AttachmentRepository struct {
+	db *sql.DB
+}
+
+func NewAttachmentRepository(db *sql.DB) *AttachmentRepository {
+	return &AttachmentRepository{db: db}
+}
+
+const attachmentColumns = `id, uploader_id, room_id, message_id, storage_key, file_name, content_type, size, width, height, thumbnails, created_at`
+
+func scanAttachment(row rowScanner) (*models.Attachment, error) {
+	attachment := &models.Attachment{}
+	err := row.Scan(
+		&attachment.ID,
+		&attachment.UploaderID,
+		&attachment.RoomID,
+		&attachment.MessageID,
+		&attachment.StorageKey,
+		&attachment.FileName,
+		&attachment.ContentType,
+		&attachment.Size,
+		&attachment.Width,
+		&attachment.Height,
+		&attachment.Thumbnails,
+		&attachment.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	return attachment, err
+}
+
+// Create records a file the uploader has just stored. attachment.ID must
+// be set, since thumbnail URLs are built from it. It returns
+// ErrQuotaExceeded if the file doesn't fit in the uploader's storage quota;
+// quota applies to users without one of their own, and 0 means unlimited.
+func (r *AttachmentRepository) Create(attachment *models.Attachment, quota int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the user serializes their uploads, so two can't both fit in
+	// the last of the quota
+	err = checkQuota(tx, `
+        SELECT COALESCE(storage_quota, $2) FROM users WHERE id = $1
+        FOR NO KEY UPDATE
+    `, `SELECT COALESCE(SUM(size), 0) FROM attachments WHERE uploader_id = $1`,
+		attachment.UploaderID, quota, attachment.Size)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO attachments (id, uploader_id, storage_key, file_name, content_type, size, width, height, thumbnails, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING created_at
+    `
+
+	err = tx.QueryRow(
+		query,
+		attachment.ID,
+		attachment.UploaderID,
+		attachment.StorageKey,
+		attachment.FileName,
+		attachment.ContentType,
+		attachment.Size,
+		attachment.Width,
+		attachment.Height,
+		attachment.Thumbnails,
+		time.Now(),
+	).Scan(&attachment.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *AttachmentRepository) FindByID(id uuid.UUID) (*models.Attachment, error) {
+	return scanAttachment(r.db.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id = $1`, id))
+}
+
+// AttachToRoom ties the uploader's attachment to the room it is being sent
+// in. An attachment already sent to another room, or uploaded by someone
+// else, returns ErrAttachmentNotFound. Sending a file to a room for the
+// first time counts it against the room's storage quota, which defaults to
+// quota as in Create.
+func (r *AttachmentRepository) AttachToRoom(id, uploaderID, roomID uuid.UUID, quota int64) (*models.Attachment, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        SELECT ` + attachmentColumns + ` FROM attachments
+        WHERE id = $1 AND uploader_id = $2 AND (room_id IS NULL OR room_id = $3)
+        FOR UPDATE
+    `
+	attachment, err := scanAttachment(tx.QueryRow(query, id, uploaderID, roomID))
+	if err != nil {
+		return nil, err
+	}
+
+	// Sent here before, so already counted
+	if attachment.RoomID != nil {
+		return attachment, tx.Commit()
+	}
+
+	err = checkQuota(tx, `
+        SELECT COALESCE(storage_quota, $2) FROM rooms WHERE id = $1
+        FOR NO KEY UPDATE
+    `, `SELECT COALESCE(SUM(size), 0) FROM attachments WHERE room_id = $1`,
+		roomID, quota, attachment.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE attachments SET room_id = $1 WHERE id = $2`, roomID, id); err != nil {
+		return nil, err
+	}
+	attachment.RoomID = &roomID
+
+	return attachment, tx.Commit()
+}
+
+// checkQuota returns ErrQuotaExceeded unless size more bytes fit in the
+// owner's quota. limitQuery locks the owner and selects its quota, given
+// the owner's ID and the default; usageQuery sums what the owner stores.
+func checkQuota(tx *sql.Tx, limitQuery, usageQuery string, ownerID uuid.UUID, quota, size int64) error {
+	var limit int64
+	if err := tx.QueryRow(limitQuery, ownerID, quota).Scan(&limit); err != nil {
+		return err
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	var used int64
+	if err := tx.QueryRow(usageQuery, ownerID).Scan(&used); err != nil {
+		return err
+	}
+	if used+size > limit {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// StorageUsage reports how much of their quota the user's attachments use,
+// with quota as the default as in Create
+func (r *AttachmentRepository) StorageUsage(userID uuid.UUID, quota int64) (*models.StorageUsage, error) {
+	query := `
+        SELECT COALESCE(u.storage_quota, $2),
+               COALESCE(SUM(a.size), 0),
+               COUNT(a.id)
+        FROM users u
+        LEFT JOIN attachments a ON a.uploader_id = u.id
+        WHERE u.id = $1
+        GROUP BY u.id
+    `
+
+	var limit int64
+	usage := &models.StorageUsage{}
+	if err := r.db.QueryRow(query, userID, quota).Scan(&limit, &usage.Used, &usage.Attachments); err != nil {
+		return nil, err
+	}
+
+	if limit > 0 {
+		remaining := max(0, limit-usage.Used)
+		usage.Quota = &limit
+		usage.Remaining = &remaining
+	}
+	return usage, nil
+}
+
+// Quarantine records a flagged upload, already moved to file.StorageKey
+func (r *AttachmentRepository) Quarantine(file *models.QuarantinedFile) error {
+	query := `
+        INSERT INTO quarantined_files (id, uploader_id, storage_key, file_name, content_type, size, signature, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING created_at
+    `
+
+	file.ID = uuid.New()
+	return r.db.QueryRow(
+		query,
+		file.ID,
+		file.UploaderID,
+		file.StorageKey,
+		file.FileName,
+		file.ContentType,
+		file.Size,
+		file.Signature,
+		time.Now(),
+	).Scan(&file.CreatedAt)
+}
+
+// SetMessage records the message that first sent the attachment
+func (r *AttachmentRepository) SetMessage(id, messageID uuid.UUID) error {
+	_, err := r.db.Exec(`UPDATE attachments SET message_id = $1 WHERE id = $2 AND message_id IS NULL`, messageID, id)
+	return err
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageCursor marks a position in a room's history. Messages are ordered
+// by (created_at, id) so the cursor stays stable while new messages arrive.
+type MessageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// Encode returns an opaque string that clients pass back as before/after
+func (c *MessageCursor) Encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeMessageCursor parses a cursor produced by Encode
+func DecodeMessageCursor(s string) (*MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	return &MessageCursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// PageQuery selects a page of messages relative to a cursor. With Before
+// set (or neither set) the page holds the newest messages older than it;
+// with After set it holds the oldest messages newer than it.
+type PageQuery struct {
+	Before *MessageCursor
+	After  *MessageCursor
+	Limit  int
+}
+
+// seek returns the keyset condition and sort order for the page, using
+// argument placeholders starting at $n
+func (p PageQuery) seek(n int) (where string, order string, args []interface{}) {
+	switch {
+	case p.After != nil:
+		return fmt.Sprintf("AND (m.created_at, m.id) > ($%d, $%d)", n, n+1),
+			"ORDER BY m.created_at ASC, m.id ASC",
+			[]interface{}{p.After.CreatedAt, p.After.ID}
+	case p.Before != nil:
+		return fmt.Sprintf("AND (m.created_at, m.id) < ($%d, $%d)", n, n+1),
+			"ORDER BY m.created_at DESC, m.id DESC",
+			[]interface{}{p.Before.CreatedAt, p.Before.ID}
+	default:
+		return "", "ORDER BY m.created_at DESC, m.id DESC", nil
+	}
+}
@@ -0,0 +1,347 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/halizadz/chat-app-backend/internal/models"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrAlreadyInvited means the user already has a pending invitation
+	// to the room
+	ErrAlreadyInvited = errors.New("user already has a pending invitation")
+
+	// ErrInvitationNotFound means no pending invitation matches
+	ErrInvitationNotFound = errors.New("invitation not found")
+
+	// ErrInviteLinkInvalid means the invite link does not exist, expired,
+	// ran out of uses or was revoked
+	ErrInviteLinkInvalid = errors.New("invite link is invalid or expired")
+)
+
+type InvitationRepository struct {
+	db *sql.DB
+}
+
+func NewInvitationRepository(db *sql.DB) *InvitationRepository {
+	return &InvitationRepository{db: db}
+}
+
+const invitationSelect = `
+        SELECT i.id, i.room_id, r.name, i.inviter_id, COALESCE(u.username, ''),
+               i.invitee_id, i.status, i.created_at, i.responded_at
+        FROM room_invitations i
+        JOIN rooms r ON r.id = i.room_id
+        LEFT JOIN users u ON u.id = i.inviter_id
+    `
+
+func scanInvitation(row rowScanner) (*models.RoomInvitation, error) {
+	invitation := &models.RoomInvitation{}
+	err := row.Scan(
+		&invitation.ID,
+		&invitation.RoomID,
+		&invitation.RoomName,
+		&invitation.InviterID,
+		&invitation.InviterUsername,
+		&invitation.InviteeID,
+		&invitation.Status,
+		&invitation.CreatedAt,
+		&invitation.RespondedAt,
+	)
+	return invitation, err
+}
+
+func (r *InvitationRepository) queryInvitations(query string, args ...interface{}) ([]*models.RoomInvitation, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*models.RoomInvitation
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	return invitations, rows.Err()
+}
+
+// CreateInvitation stores a pending invitation. It returns
+// ErrAlreadyInvited if the invitee already has one for the room.
+func (r *InvitationRepository) CreateInvitation(invitation *models.RoomInvitation) error {
+	query := `
+        INSERT INTO room_invitations (id, room_id, inviter_id, invitee_id, status, created_at)
+        VALUES ($1, $2, $3, $4, 'pending', $5)
+        RETURNING status, created_at
+    `
+
+	invitation.ID = uuid.New()
+	err := r.db.QueryRow(
+		query,
+		invitation.ID,
+		invitation.RoomID,
+		invitation.InviterID,
+		invitation.InviteeID,
+		time.Now(),
+	).Scan(&invitation.Status, &invitation.CreatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrAlreadyInvited
+	}
+	return err
+}
+
+// FindInvitation returns an invitation by ID in any status
+func (r *InvitationRepository) FindInvitation(id uuid.UUID) (*models.RoomInvitation, error) {
+	invitation, err := scanInvitation(r.db.QueryRow(invitationSelect+` WHERE i.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	return invitation, err
+}
+
+// GetPendingForUser lists invitations waiting on the user, newest first
+func (r *InvitationRepository) GetPendingForUser(userID uuid.UUID) ([]*models.RoomInvitation, error) {
+	return r.queryInvitations(invitationSelect+`
+        WHERE i.invitee_id = $1 AND i.status = 'pending'
+        ORDER BY i.created_at DESC
+    `, userID)
+}
+
+// GetPendingForRoom lists the room's outstanding invitations, newest first
+func (r *InvitationRepository) GetPendingForRoom(roomID uuid.UUID) ([]*models.RoomInvitation, error) {
+	return r.queryInvitations(invitationSelect+`
+        WHERE i.room_id = $1 AND i.status = 'pending'
+        ORDER BY i.created_at DESC
+    `, roomID)
+}
+
+// RespondToInvitation accepts or declines the invitee's pending
+// invitation. Accepting adds them to the room as a member.
+func (r *InvitationRepository) RespondToInvitation(id, inviteeID uuid.UUID, accept bool) (*models.RoomInvitation, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	status := "declined"
+	if accept {
+		status = "accepted"
+	}
+
+	now := time.Now()
+	var roomID uuid.UUID
+	err = tx.QueryRow(`
+        UPDATE room_invitations SET status = $1, responded_at = $2
+        WHERE id = $3 AND invitee_id = $4 AND status = 'pending'
+        RETURNING room_id
+    `, status, now, id, inviteeID).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if accept {
+		if err := checkNotBanned(tx, roomID, inviteeID); err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(`
+            INSERT INTO room_members (id, room_id, user_id, role, joined_at)
+            VALUES ($1, $2, $3, $4, $5)
+            ON CONFLICT (room_id, user_id) DO NOTHING
+        `, uuid.New(), roomID, inviteeID, models.RoleMember, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return r.FindInvitation(id)
+}
+
+// CancelInvitation withdraws a pending invitation to the room
+func (r *InvitationRepository) CancelInvitation(id, roomID uuid.UUID) error {
+	query := `
+        UPDATE room_invitations SET status = 'cancelled', responded_at = $1
+        WHERE id = $2 AND room_id = $3 AND status = 'pending'
+    `
+	result, err := r.db.Exec(query, time.Now(), id, roomID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// CreateInviteLink stores a new invite link; link.Code must be set
+func (r *InvitationRepository) CreateInviteLink(link *models.InviteLink) error {
+	query := `
+        INSERT INTO room_invite_links (id, room_id, code, created_by, expires_at, max_uses, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING use_count, created_at
+    `
+
+	link.ID = uuid.New()
+	return r.db.QueryRow(
+		query,
+		link.ID,
+		link.RoomID,
+		link.Code,
+		link.CreatedBy,
+		link.ExpiresAt,
+		link.MaxUses,
+		time.Now(),
+	).Scan(&link.UseCount, &link.CreatedAt)
+}
+
+// GetInviteLinks lists the room's usable invite links, newest first
+func (r *InvitationRepository) GetInviteLinks(roomID uuid.UUID) ([]*models.InviteLink, error) {
+	query := `
+        SELECT id, room_id, code, created_by, expires_at, max_uses, use_count, created_at
+        FROM room_invite_links
+        WHERE room_id = $1
+          AND revoked_at IS NULL
+          AND (expires_at IS NULL OR expires_at > $2)
+          AND (max_uses IS NULL OR use_count < max_uses)
+        ORDER BY created_at DESC
+    `
+
+	rows, err := r.db.Query(query, roomID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []*models.InviteLink
+	for rows.Next() {
+		link := &models.InviteLink{}
+		var createdBy uuid.NullUUID
+		err := rows.Scan(
+			&link.ID,
+			&link.RoomID,
+			&link.Code,
+			&createdBy,
+			&link.ExpiresAt,
+			&link.MaxUses,
+			&link.UseCount,
+			&link.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		link.CreatedBy = createdBy.UUID
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+// RevokeInviteLink stops a room's invite link from being used
+func (r *InvitationRepository) RevokeInviteLink(roomID, linkID uuid.UUID) error {
+	query := `
+        UPDATE room_invite_links SET revoked_at = $1
+        WHERE id = $2 AND room_id = $3 AND revoked_at IS NULL
+    `
+	result, err := r.db.Exec(query, time.Now(), linkID, roomID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInviteLinkInvalid
+	}
+	return nil
+}
+
+// RedeemInviteLink adds the user to the link's room as a member, using up
+// one of its uses. Users who are already members don't use it up; joined
+// reports whether the user was added.
+func (r *InvitationRepository) RedeemInviteLink(code string, userID uuid.UUID) (roomID uuid.UUID, joined bool, err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	defer tx.Rollback()
+
+	// Lock the link so concurrent redemptions can't exceed max_uses
+	var linkID uuid.UUID
+	now := time.Now()
+	err = tx.QueryRow(`
+        SELECT id, room_id FROM room_invite_links
+        WHERE code = $1
+          AND revoked_at IS NULL
+          AND (expires_at IS NULL OR expires_at > $2)
+          AND (max_uses IS NULL OR use_count < max_uses)
+        FOR UPDATE
+    `, code, now).Scan(&linkID, &roomID)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, false, ErrInviteLinkInvalid
+	}
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	if err := checkNotBanned(tx, roomID, userID); err != nil {
+		return uuid.Nil, false, err
+	}
+
+	result, err := tx.Exec(`
+        INSERT INTO room_members (id, room_id, user_id, role, joined_at)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (room_id, user_id) DO NOTHING
+    `, uuid.New(), roomID, userID, models.RoleMember, now)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	added, err := result.RowsAffected()
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	if added == 0 {
+		return roomID, false, nil
+	}
+
+	_, err = tx.Exec(`UPDATE room_invite_links SET use_count = use_count + 1 WHERE id = $1`, linkID)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	// Anyone joining makes earlier invitations to the room moot
+	_, err = tx.Exec(`
+        UPDATE room_invitations SET status = 'accepted', responded_at = $1
+        WHERE room_id = $2 AND invitee_id = $3 AND status = 'pending'
+    `, now, roomID, userID)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	return roomID, true, tx.Commit()
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/halizadz/chat-app-backend/internal/models"
+)
+
+// LinkPreviewRepository caches fetched link previews, shared by every
+// replica, so a link posted again isn't fetched again
+type LinkPreviewRepository struct {
+	db *sql.DB
+}
+
+func NewLinkPreviewRepository(db *sql.DB) *LinkPreviewRepository {
+	return &LinkPreviewRepository{db: db}
+}
+
+// Find returns the cached preview of url. found is false if nothing
+// unexpired is cached; a page known to have no preview is found with a nil
+// preview.
+func (r *LinkPreviewRepository) Find(url string) (preview *models.LinkPreview, found bool, err error) {
+	var data []byte
+	err = r.db.QueryRow(`
+        SELECT preview FROM link_previews
+        WHERE url = $1 AND expires_at > $2
+    `, url, time.Now()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if data == nil {
+		return nil, true, nil
+	}
+	preview = &models.LinkPreview{}
+	if err := preview.Scan(data); err != nil {
+		return nil, false, err
+	}
+	return preview, true, nil
+}
+
+// Save caches the preview of url until expiresAt; a nil preview records
+// that the page has none
+func (r *LinkPreviewRepository) Save(url string, preview *models.LinkPreview, expiresAt time.Time) error {
+	query := `
+        INSERT INTO link_previews (url, preview, fetched_at, expires_at)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (url) DO UPDATE
+        SET preview = EXCLUDED.preview, fetched_at = EXCLUDED.fetched_at, expires_at = EXCLUDED.expires_at
+    `
+
+	_, err := r.db.Exec(query, url, preview, time.Now(), expiresAt)
+	return err
+}
+
+// DeleteExpired removes cache entries that can no longer be used
+func (r *LinkPreviewRepository) DeleteExpired() (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM link_previews WHERE expires_at <= $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -2,10 +2,13 @@ package repository
 
 import (
 	"database/sql"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/halizadz/chat-app-backend/internal/models"
+	"github.com/lib/pq"
 )
 
 type MessageRepository struct {
@@ -16,17 +19,128 @@ func NewMessageRepository(db *sql.DB) *MessageRepository {
 	return &MessageRepository{db: db}
 }
 
+// messageSelect is the column list and joins shared by every query that
+// returns full messages; scanMessage reads rows in the same order.
+const messageSelect = `
+        SELECT m.id, m.room_id, m.sender_id, m.content, m.type, m.file_url, 
+               m.file_name, m.file_size, m.width, m.height, m.thumbnails,
+               m.link_preview, m.created_at, m.updated_at,
+               COALESCE(m.updated_at > m.created_at, false) as is_edited,
+               COALESCE(m.content = '[DELETED]', false) as is_deleted,
+               m.reply_to_id, m.thread_root_id, COALESCE(m.seq, 0),
+               (SELECT COUNT(*) FROM messages t WHERE t.thread_root_id = m.id) as reply_count,
+               u.id, u.username, u.email, u.avatar_url,
+               p.id, p.sender_id, pu.username, LEFT(p.content, 200), p.type,
+               COALESCE(p.content = '[DELETED]', false)
+        FROM messages m
+        JOIN users u ON m.sender_id = u.id
+        LEFT JOIN messages p ON p.id = m.reply_to_id
+        LEFT JOIN users pu ON pu.id = p.sender_id
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row rowScanner) (*models.Message, error) {
+	msg := &models.Message{
+		Sender: &models.User{},
+	}
+
+	var replyToID, threadRootID, parentID, parentSenderID uuid.NullUUID
+	var parentUsername, parentContent, parentType sql.NullString
+	var parentDeleted bool
+	var linkPreview []byte
+
+	err := row.Scan(
+		&msg.ID,
+		&msg.RoomID,
+		&msg.SenderID,
+		&msg.Content,
+		&msg.Type,
+		&msg.FileURL,
+		&msg.FileName,
+		&msg.FileSize,
+		&msg.Width,
+		&msg.Height,
+		&msg.Thumbnails,
+		&linkPreview,
+		&msg.CreatedAt,
+		&msg.UpdatedAt,
+		&msg.IsEdited,
+		&msg.IsDeleted,
+		&replyToID,
+		&threadRootID,
+		&msg.Seq,
+		&msg.ReplyCount,
+		&msg.Sender.ID,
+		&msg.Sender.Username,
+		&msg.Sender.Email,
+		&msg.Sender.AvatarURL,
+		&parentID,
+		&parentSenderID,
+		&parentUsername,
+		&parentContent,
+		&parentType,
+		&parentDeleted,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if linkPreview != nil {
+		msg.LinkPreview = &models.LinkPreview{}
+		if err := msg.LinkPreview.Scan(linkPreview); err != nil {
+			return nil, err
+		}
+	}
+	if replyToID.Valid {
+		msg.ReplyToID = &replyToID.UUID
+	}
+	if threadRootID.Valid {
+		msg.ThreadRootID = &threadRootID.UUID
+	}
+	if parentID.Valid {
+		msg.ReplyTo = &models.MessagePreview{
+			ID:             parentID.UUID,
+			SenderID:       parentSenderID.UUID,
+			SenderUsername: parentUsername.String,
+			Content:        parentContent.String,
+			Type:           parentType.String,
+			IsDeleted:      parentDeleted,
+		}
+	}
+
+	return msg, nil
+}
+
 func (r *MessageRepository) Create(message *models.Message) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Claim the room's next sequence number; the row lock keeps
+	// concurrent senders in the same room strictly ordered
+	err = tx.QueryRow(
+		`UPDATE rooms SET last_seq = last_seq + 1 WHERE id = $1 RETURNING last_seq`,
+		message.RoomID,
+	).Scan(&message.Seq)
+	if err != nil {
+		return err
+	}
+
 	query := `
-        INSERT INTO messages (id, room_id, sender_id, content, type, file_url, file_name, file_size, created_at, updated_at)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-        RETURNING id, created_at
+        INSERT INTO messages (id, room_id, sender_id, content, type, file_url, file_name, file_size, width, height, thumbnails, reply_to_id, thread_root_id, seq, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+        RETURNING id, created_at, updated_at
     `
 
 	message.ID = uuid.New()
 	now := time.Now()
 
-	return r.db.QueryRow(
+	err = tx.QueryRow(
 		query,
 		message.ID,
 		message.RoomID,
@@ -36,77 +150,112 @@ func (r *MessageRepository) Create(message *models.Message) error {
 		message.FileURL,
 		message.FileName,
 		message.FileSize,
+		message.Width,
+		message.Height,
+		message.Thumbnails,
+		message.ReplyToID,
+		message.ThreadRootID,
+		message.Seq,
 		now,
 		now,
-	).Scan(&message.ID, &message.CreatedAt)
+	).Scan(&message.ID, &message.CreatedAt, &message.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (r *MessageRepository) GetByRoomID(roomID uuid.UUID, limit, offset int) ([]*models.Message, error) {
-	query := `
-        SELECT m.id, m.room_id, m.sender_id, m.content, m.type, m.file_url, 
-               m.file_name, m.file_size, m.created_at, m.updated_at,
-               COALESCE(m.updated_at > m.created_at, false) as is_edited,
-               COALESCE(m.content = '[DELETED]', false) as is_deleted,
-               u.id, u.username, u.email, u.avatar_url
-        FROM messages m
-        JOIN users u ON m.sender_id = u.id
-        WHERE m.room_id = $1
-        ORDER BY m.created_at DESC
-        LIMIT $2 OFFSET $3
+// GetAfterSeq returns up to limit messages in a room with a sequence number
+// greater than afterSeq, oldest first
+func (r *MessageRepository) GetAfterSeq(roomID, viewerID uuid.UUID, afterSeq int64, limit int) ([]*models.Message, error) {
+	query := messageSelect + `
+        WHERE m.room_id = $1 AND m.seq > $2
+        ORDER BY m.seq ASC
+        LIMIT $3
     `
 
-	rows, err := r.db.Query(query, roomID, limit, offset)
+	messages, _, err := r.queryPage(query, []interface{}{roomID, afterSeq, limit}, limit)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var messages []*models.Message
-	for rows.Next() {
-		msg := &models.Message{
-			Sender: &models.User{},
-		}
+	if err := r.attachReactions(messages, viewerID); err != nil {
+		return nil, err
+	}
 
-		var isEdited, isDeleted bool
+	return messages, nil
+}
 
-		err := rows.Scan(
-			&msg.ID,
-			&msg.RoomID,
-			&msg.SenderID,
-			&msg.Content,
-			&msg.Type,
-			&msg.FileURL,
-			&msg.FileName,
-			&msg.FileSize,
-			&msg.CreatedAt,
-			&msg.UpdatedAt,
-			&isEdited,
-			&isDeleted,
-			&msg.Sender.ID,
-			&msg.Sender.Username,
-			&msg.Sender.Email,
-			&msg.Sender.AvatarURL,
-		)
-		if err != nil {
-			return nil, err
-		}
+// GetByRoomID returns a page of room history in chronological order and
+// whether more messages lie beyond it in the paging direction. Reactions
+// are reported from viewerID's point of view.
+func (r *MessageRepository) GetByRoomID(roomID, viewerID uuid.UUID, page PageQuery) ([]*models.Message, bool, error) {
+	seek, order, seekArgs := page.seek(2)
+	args := append([]interface{}{roomID}, seekArgs...)
+	args = append(args, page.Limit+1)
 
-		msg.IsEdited = isEdited
-		msg.IsDeleted = isDeleted
+	query := messageSelect + `
+        WHERE m.room_id = $1
+        ` + seek + `
+        ` + order + fmt.Sprintf(`
+        LIMIT $%d
+    `, len(args))
 
-		// Get read status
-		readBy, _ := r.GetReadBy(msg.ID)
-		msg.ReadBy = readBy
+	messages, hasMore, err := r.queryPage(query, args, page.Limit)
+	if err != nil {
+		return nil, false, err
+	}
 
-		messages = append(messages, msg)
+	if err := r.attachReadBy(messages); err != nil {
+		return nil, false, err
+	}
+
+	if err := r.attachReactions(messages, viewerID); err != nil {
+		return nil, false, err
 	}
 
 	// Reverse to get chronological order
+	if page.After == nil {
+		reverseMessages(messages)
+	}
+
+	return messages, hasMore, nil
+}
+
+// queryPage runs a query fetching limit+1 rows and reports whether the
+// extra row existed
+func (r *MessageRepository) queryPage(query string, args []interface{}, limit int) ([]*models.Message, bool, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var messages []*models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	return messages, hasMore, nil
+}
+
+func reverseMessages(messages []*models.Message) {
 	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
 		messages[i], messages[j] = messages[j], messages[i]
 	}
-
-	return messages, nil
 }
 
 func (r *MessageRepository) MarkAsRead(messageID, userID uuid.UUID) error {
@@ -120,21 +269,54 @@ func (r *MessageRepository) MarkAsRead(messageID, userID uuid.UUID) error {
 	return err
 }
 
-// MarkRoomMessagesAsRead marks all messages in a room as read for a user
-func (r *MessageRepository) MarkRoomMessagesAsRead(roomID, userID uuid.UUID) error {
+// ReadMarker is the newest message a user has read in a room
+type ReadMarker struct {
+	MessageID uuid.UUID
+	Seq       int64
+}
+
+// MarkRoomMessagesAsRead marks messages in a room as read for a user, up to
+// and including upToSeq (0 marks every message). It returns the newest
+// message covered, or nil for an empty room, and whether anything was
+// newly marked.
+func (r *MessageRepository) MarkRoomMessagesAsRead(roomID, userID uuid.UUID, upToSeq int64) (*ReadMarker, bool, error) {
 	query := `
         INSERT INTO message_read_status (id, message_id, user_id, read_at)
         SELECT uuid_generate_v4(), m.id, $2, NOW()
         FROM messages m
         WHERE m.room_id = $1
+        AND ($3::bigint = 0 OR m.seq <= $3::bigint)
         AND NOT EXISTS (
             SELECT 1 FROM message_read_status mrs 
             WHERE mrs.message_id = m.id AND mrs.user_id = $2
         )
     `
 
-	_, err := r.db.Exec(query, roomID, userID)
-	return err
+	result, err := r.db.Exec(query, roomID, userID, upToSeq)
+	if err != nil {
+		return nil, false, err
+	}
+
+	marked, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	marker := &ReadMarker{}
+	err = r.db.QueryRow(`
+        SELECT id, COALESCE(seq, 0) FROM messages
+        WHERE room_id = $1 AND ($2::bigint = 0 OR seq <= $2::bigint)
+        ORDER BY created_at DESC, id DESC
+        LIMIT 1
+    `, roomID, upToSeq).Scan(&marker.MessageID, &marker.Seq)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return marker, marked > 0, nil
 }
 
 // GetReadBy returns list of user IDs who read the message
@@ -162,61 +344,70 @@ func (r *MessageRepository) GetReadBy(messageID uuid.UUID) ([]uuid.UUID, error)
 	return readBy, nil
 }
 
-// FindByID finds a message by ID
-func (r *MessageRepository) FindByID(messageID uuid.UUID) (*models.Message, error) {
-	msg := &models.Message{
-		Sender: &models.User{},
-	}
-
-	query := `
-        SELECT m.id, m.room_id, m.sender_id, m.content, m.type, m.file_url, 
-               m.file_name, m.file_size, m.created_at, m.updated_at,
-               COALESCE(m.updated_at > m.created_at, false) as is_edited,
-               COALESCE(m.content = '[DELETED]', false) as is_deleted,
-               u.id, u.username, u.email, u.avatar_url
-        FROM messages m
-        JOIN users u ON m.sender_id = u.id
+// FindByID finds a message by ID, with reactions as seen by viewerID
+func (r *MessageRepository) FindByID(messageID, viewerID uuid.UUID) (*models.Message, error) {
+	query := messageSelect + `
         WHERE m.id = $1
     `
 
-	var isEdited, isDeleted bool
-	err := r.db.QueryRow(query, messageID).Scan(
-		&msg.ID,
-		&msg.RoomID,
-		&msg.SenderID,
-		&msg.Content,
-		&msg.Type,
-		&msg.FileURL,
-		&msg.FileName,
-		&msg.FileSize,
-		&msg.CreatedAt,
-		&msg.UpdatedAt,
-		&isEdited,
-		&isDeleted,
-		&msg.Sender.ID,
-		&msg.Sender.Username,
-		&msg.Sender.Email,
-		&msg.Sender.AvatarURL,
-	)
-
+	msg, err := scanMessage(r.db.QueryRow(query, messageID))
 	if err != nil {
 		return nil, err
 	}
 
-	msg.IsEdited = isEdited
-	msg.IsDeleted = isDeleted
+	if err := r.attachReadBy([]*models.Message{msg}); err != nil {
+		return nil, err
+	}
 
-	readBy, _ := r.GetReadBy(msg.ID)
-	msg.ReadBy = readBy
+	if err := r.attachReactions([]*models.Message{msg}, viewerID); err != nil {
+		return nil, err
+	}
 
 	return msg, nil
 }
 
+// GetThread returns the replies in a thread, oldest first
+func (r *MessageRepository) GetThread(rootID, viewerID uuid.UUID, limit, offset int) ([]*models.Message, error) {
+	query := messageSelect + `
+        WHERE m.thread_root_id = $1
+        ORDER BY m.created_at ASC, m.id ASC
+        LIMIT $2 OFFSET $3
+    `
+
+	rows, err := r.db.Query(query, rootID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := r.attachReactions(messages, viewerID); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
 // Update updates a message
 func (r *MessageRepository) Update(messageID uuid.UUID, content string) error {
+	// The preview belongs to the old content; one for the new content is
+	// fetched again. On the right-hand side, content is still the old value.
 	query := `
         UPDATE messages 
-        SET content = $1, updated_at = NOW()
+        SET content = $1, updated_at = NOW(),
+            link_preview = CASE WHEN content = $1 THEN link_preview END
         WHERE id = $2
     `
 
@@ -224,11 +415,30 @@ func (r *MessageRepository) Update(messageID uuid.UUID, content string) error {
 	return err
 }
 
+// SetLinkPreview attaches a preview of the page at url to a message. It
+// returns false if the message no longer links there, having been edited
+// or deleted while the page was fetched.
+func (r *MessageRepository) SetLinkPreview(messageID uuid.UUID, url string, preview *models.LinkPreview) (bool, error) {
+	result, err := r.db.Exec(`
+        UPDATE messages SET link_preview = $1
+        WHERE id = $2 AND strpos(content, $3) > 0
+    `, preview, messageID, url)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
 // Delete soft deletes a message
 func (r *MessageRepository) Delete(messageID uuid.UUID) error {
 	query := `
         UPDATE messages 
-        SET content = '[DELETED]', updated_at = NOW()
+        SET content = '[DELETED]', link_preview = NULL, updated_at = NOW()
         WHERE id = $1
     `
 
@@ -237,64 +447,407 @@ func (r *MessageRepository) Delete(messageID uuid.UUID) error {
 }
 
 // SearchMessages searches messages in a room
-func (r *MessageRepository) SearchMessages(roomID uuid.UUID, query string, limit, offset int) ([]*models.Message, error) {
-	searchQuery := `
-        SELECT m.id, m.room_id, m.sender_id, m.content, m.type, m.file_url, 
-               m.file_name, m.file_size, m.created_at, m.updated_at,
-               COALESCE(m.updated_at > m.created_at, false) as is_edited,
-               COALESCE(m.content = '[DELETED]', false) as is_deleted,
-               u.id, u.username, u.email, u.avatar_url
-        FROM messages m
-        JOIN users u ON m.sender_id = u.id
+func (r *MessageRepository) SearchMessages(roomID uuid.UUID, query string, page PageQuery) ([]*models.Message, bool, error) {
+	seek, order, seekArgs := page.seek(3)
+	args := append([]interface{}{roomID, "%" + query + "%"}, seekArgs...)
+	args = append(args, page.Limit+1)
+
+	searchQuery := messageSelect + `
         WHERE m.room_id = $1 
         AND m.content ILIKE $2
         AND m.content != '[DELETED]'
-        ORDER BY m.created_at DESC
-        LIMIT $3 OFFSET $4
-    `
+        ` + seek + `
+        ` + order + fmt.Sprintf(`
+        LIMIT $%d
+    `, len(args))
 
-	searchPattern := "%" + query + "%"
-	rows, err := r.db.Query(searchQuery, roomID, searchPattern, limit, offset)
+	messages, hasMore, err := r.queryPage(searchQuery, args, page.Limit)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	defer rows.Close()
 
-	var messages []*models.Message
-	for rows.Next() {
-		msg := &models.Message{
-			Sender: &models.User{},
-		}
+	// Search results are always newest first
+	if page.After != nil {
+		reverseMessages(messages)
+	}
+
+	return messages, hasMore, nil
+}
+
+// File categories a room's gallery can be filtered by
+const (
+	FileCategoryImage    = "image"
+	FileCategoryDocument = "document"
+	FileCategoryOther    = "other"
+)
+
+// fileCategory classifies the file a message sends. Documents are known
+// by the attachment's content type, or by extension for files uploaded
+// before content types were recorded.
+const fileCategory = `
+        CASE
+            WHEN m.type = 'image' THEN 'image'
+            WHEN COALESCE(a.content_type, '') ~ '^(text/|application/(pdf|rtf|msword|epub\+zip|vnd\.ms-|vnd\.openxmlformats-officedocument\.|vnd\.oasis\.opendocument\.))'
+                OR (COALESCE(a.content_type, '') = '' AND lower(m.file_name) ~ '\.(pdf|rtf|txt|csv|md|epub|docx?|xlsx?|pptx?|od[tsp])$')
+                THEN 'document'
+            ELSE 'other'
+        END`
+
+// FileFilter narrows the files listed for a room
+type FileFilter struct {
+	Category string // image, document or other; empty for any
+	SenderID *uuid.UUID
+	From     *time.Time
+	To       *time.Time
+}
+
+// GetRoomFiles pages through the files sent in a room, newest first,
+// leaving out deleted messages
+func (r *MessageRepository) GetRoomFiles(roomID uuid.UUID, filter FileFilter, page PageQuery) ([]*models.RoomFile, bool, error) {
+	args := []interface{}{roomID}
+	conds := ""
+	addCond := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds += fmt.Sprintf("\n        AND "+cond, len(args))
+	}
+	if filter.Category != "" {
+		addCond("("+fileCategory+") = $%d", filter.Category)
+	}
+	if filter.SenderID != nil {
+		addCond("m.sender_id = $%d", *filter.SenderID)
+	}
+	if filter.From != nil {
+		addCond("m.created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCond("m.created_at < $%d", *filter.To)
+	}
+
+	seek, order, seekArgs := page.seek(len(args) + 1)
+	args = append(args, seekArgs...)
+	args = append(args, page.Limit+1)
 
-		var isEdited, isDeleted bool
+	// Attachment URLs name the attachment, which has the content type
+	query := `
+        SELECT m.id, a.id, ` + fileCategory + `,
+               m.file_url, COALESCE(m.file_name, ''), COALESCE(m.file_size, 0),
+               COALESCE(a.content_type, ''), m.width, m.height, m.thumbnails,
+               u.id, u.username, u.avatar_url, m.created_at
+        FROM messages m
+        JOIN users u ON u.id = m.sender_id
+        LEFT JOIN attachments a ON a.id = CASE
+            WHEN m.file_url ~ '^/api/attachments/[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$'
+            THEN substring(m.file_url from 18)::uuid
+        END
+        WHERE m.room_id = $1
+        AND m.file_url IS NOT NULL
+        AND m.content != '[DELETED]'` + conds + `
+        ` + seek + `
+        ` + order + fmt.Sprintf(`
+        LIMIT $%d
+    `, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
 
+	var files []*models.RoomFile
+	for rows.Next() {
+		file := &models.RoomFile{Sender: &models.User{}}
+		var attachmentID uuid.NullUUID
 		err := rows.Scan(
-			&msg.ID,
-			&msg.RoomID,
-			&msg.SenderID,
-			&msg.Content,
-			&msg.Type,
-			&msg.FileURL,
-			&msg.FileName,
-			&msg.FileSize,
-			&msg.CreatedAt,
-			&msg.UpdatedAt,
-			&isEdited,
-			&isDeleted,
-			&msg.Sender.ID,
-			&msg.Sender.Username,
-			&msg.Sender.Email,
-			&msg.Sender.AvatarURL,
+			&file.MessageID,
+			&attachmentID,
+			&file.Category,
+			&file.FileURL,
+			&file.FileName,
+			&file.FileSize,
+			&file.ContentType,
+			&file.Width,
+			&file.Height,
+			&file.Thumbnails,
+			&file.Sender.ID,
+			&file.Sender.Username,
+			&file.Sender.AvatarURL,
+			&file.CreatedAt,
 		)
 		if err != nil {
-			return nil, err
+			return nil, false, err
+		}
+		if attachmentID.Valid {
+			file.AttachmentID = &attachmentID.UUID
 		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
 
-		msg.IsEdited = isEdited
-		msg.IsDeleted = isDeleted
+	hasMore := len(files) > page.Limit
+	if hasMore {
+		files = files[:page.Limit]
+	}
+
+	// The gallery is always newest first
+	if page.After != nil {
+		slices.Reverse(files)
+	}
+
+	return files, hasMore, nil
+}
+
+// SearchFilter narrows a full-text search across the caller's rooms
+type SearchFilter struct {
+	Query    string
+	RoomID   *uuid.UUID
+	SenderID *uuid.UUID
+	From     *time.Time
+	To       *time.Time
+	Type     string // text, file or image; empty for any
+	Limit    int
+	Offset   int
+}
+
+// SearchAll runs a full-text search over every room userID belongs to,
+// best matches first. Snippets are HTML-escaped message excerpts with the
+// matched terms wrapped in <mark> tags.
+func (r *MessageRepository) SearchAll(userID uuid.UUID, filter SearchFilter) ([]*models.SearchResult, bool, error) {
+	args := []interface{}{userID, filter.Query}
+	conds := ""
+	addCond := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds += fmt.Sprintf("\n        AND "+cond, len(args))
+	}
+	if filter.RoomID != nil {
+		addCond("m.room_id = $%d", *filter.RoomID)
+	}
+	if filter.SenderID != nil {
+		addCond("m.sender_id = $%d", *filter.SenderID)
+	}
+	if filter.From != nil {
+		addCond("m.created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCond("m.created_at < $%d", *filter.To)
+	}
+	if filter.Type != "" {
+		addCond("m.type = $%d", filter.Type)
+	}
+	args = append(args, filter.Limit+1, filter.Offset)
+
+	// Matching uses the same expression as idx_messages_content so the GIN
+	// index applies; snippets are only built for the page being returned
+	query := `
+        WITH q AS (
+            SELECT websearch_to_tsquery('english', $2) AS query
+        ), hits AS (
+            SELECT m.id, m.content, m.created_at, r.name, r.type,
+                   ts_rank(to_tsvector('english', m.content), q.query) AS rank
+            FROM messages m
+            JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = $1
+            JOIN rooms r ON r.id = m.room_id
+            CROSS JOIN q
+            WHERE to_tsvector('english', m.content) @@ q.query
+            AND m.content != '[DELETED]'` + conds + fmt.Sprintf(`
+            ORDER BY rank DESC, m.created_at DESC, m.id DESC
+            LIMIT $%d OFFSET $%d
+        )
+        SELECT h.id, h.name, h.type, h.rank,
+               ts_headline('english',
+                   replace(replace(replace(h.content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+                   q.query, 'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15')
+        FROM hits h
+        CROSS JOIN q
+        ORDER BY h.rank DESC, h.created_at DESC, h.id DESC
+    `, len(args)-1, len(args))
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
 
+	var results []*models.SearchResult
+	for rows.Next() {
+		result := &models.SearchResult{Message: &models.Message{}}
+		if err := rows.Scan(&result.Message.ID, &result.RoomName, &result.RoomType, &result.Rank, &result.Snippet); err != nil {
+			return nil, false, err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(results) > filter.Limit
+	if hasMore {
+		results = results[:filter.Limit]
+	}
+
+	if err := r.attachSearchMessages(results, userID); err != nil {
+		return nil, false, err
+	}
+
+	return results, hasMore, nil
+}
+
+// attachSearchMessages replaces each result's placeholder message with the
+// full message, loaded in one query
+func (r *MessageRepository) attachSearchMessages(results []*models.SearchResult, viewerID uuid.UUID) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(results))
+	byID := make(map[uuid.UUID]*models.SearchResult, len(results))
+	for i, result := range results {
+		ids[i] = result.Message.ID.String()
+		byID[result.Message.ID] = result
+	}
+
+	rows, err := r.db.Query(messageSelect+`
+        WHERE m.id = ANY($1::uuid[])
+    `, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	messages := make([]*models.Message, 0, len(results))
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return err
+		}
+		if result, ok := byID[msg.ID]; ok {
+			result.Message = msg
+		}
 		messages = append(messages, msg)
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	return messages, nil
+	return r.attachReactions(messages, viewerID)
+}
+
+// AddReaction records userID reacting to a message with emoji. It reports
+// false if the user had already reacted with that emoji.
+func (r *MessageRepository) AddReaction(messageID, userID uuid.UUID, emoji string) (bool, error) {
+	query := `
+        INSERT INTO message_reactions (id, message_id, user_id, emoji, created_at)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (message_id, user_id, emoji) DO NOTHING
+    `
+
+	result, err := r.db.Exec(query, uuid.New(), messageID, userID, emoji, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveReaction removes userID's emoji reaction. It reports false if there
+// was nothing to remove.
+func (r *MessageRepository) RemoveReaction(messageID, userID uuid.UUID, emoji string) (bool, error) {
+	query := `DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`
+
+	result, err := r.db.Exec(query, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CountReactions returns how many users reacted to a message with emoji
+func (r *MessageRepository) CountReactions(messageID uuid.UUID, emoji string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2`
+	err := r.db.QueryRow(query, messageID, emoji).Scan(&count)
+	return count, err
+}
+
+// attachReadBy loads the readers of all messages in one query
+func (r *MessageRepository) attachReadBy(messages []*models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(messages))
+	byID := make(map[uuid.UUID]*models.Message, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID.String()
+		byID[msg.ID] = msg
+	}
+
+	query := `
+        SELECT message_id, user_id FROM message_read_status
+        WHERE message_id = ANY($1::uuid[])
+        ORDER BY read_at
+    `
+
+	rows, err := r.db.Query(query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID, userID uuid.UUID
+		if err := rows.Scan(&messageID, &userID); err != nil {
+			return err
+		}
+		if msg, ok := byID[messageID]; ok {
+			msg.ReadBy = append(msg.ReadBy, userID)
+		}
+	}
+
+	return rows.Err()
+}
+
+// attachReactions loads aggregated reactions for all messages in one query
+func (r *MessageRepository) attachReactions(messages []*models.Message, viewerID uuid.UUID) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(messages))
+	byID := make(map[uuid.UUID]*models.Message, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID.String()
+		byID[msg.ID] = msg
+	}
+
+	query := `
+        SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2)
+        FROM message_reactions
+        WHERE message_id = ANY($1::uuid[])
+        GROUP BY message_id, emoji
+        ORDER BY MIN(created_at)
+    `
+
+	rows, err := r.db.Query(query, pq.Array(ids), viewerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID uuid.UUID
+		var reaction models.Reaction
+		if err := rows.Scan(&messageID, &reaction.Emoji, &reaction.Count, &reaction.ReactedByMe); err != nil {
+			return err
+		}
+		if msg, ok := byID[messageID]; ok {
+			msg.Reactions = append(msg.Reactions, reaction)
+		}
+	}
+
+	return rows.Err()
 }
@@ -2,14 +2,31 @@ package repository
 
 import (
     "database/sql"
+    "errors"
     "fmt"
     "hash/fnv"
+    "strings"
     "time"
 
     "github.com/google/uuid"
     "github.com/halizadz/chat-app-backend/internal/models"
 )
 
+var (
+    // ErrBanned means the user is banned from the room
+    ErrBanned = errors.New("user is banned from this room")
+
+    // ErrNotMember means the user a change targets isn't in the room
+    ErrNotMember = errors.New("user is not a member of this room")
+
+    // ErrRoleChanged means the acting member's role changed underneath them
+    ErrRoleChanged = errors.New("your role in this room has changed")
+
+    // ErrOutranked means the target of a change doesn't rank below the
+    // member making it
+    ErrOutranked = errors.New("target does not rank below you")
+)
+
 type RoomRepository struct {
     db *sql.DB
 }
@@ -20,13 +37,16 @@ func NewRoomRepository(db *sql.DB) *RoomRepository {
 
 func (r *RoomRepository) Create(room *models.Room) error {
     query := `
-        INSERT INTO rooms (id, name, description, type, created_by, created_at, updated_at)
-        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        INSERT INTO rooms (id, name, description, type, visibility, created_by, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
         RETURNING id, created_at, updated_at
     `
     
     room.ID = uuid.New()
     now := time.Now()
+    if room.Visibility == "" {
+        room.Visibility = "private"
+    }
     
     return r.db.QueryRow(
         query,
@@ -34,6 +54,7 @@ func (r *RoomRepository) Create(room *models.Room) error {
         room.Name,
         room.Description,
         room.Type,
+        room.Visibility,
         room.CreatedBy,
         now,
         now,
@@ -43,7 +64,7 @@ func (r *RoomRepository) Create(room *models.Room) error {
 func (r *RoomRepository) FindByID(id uuid.UUID) (*models.Room, error) {
     room := &models.Room{}
     query := `
-        SELECT id, name, description, type, created_by, created_at, updated_at
+        SELECT id, name, description, type, visibility, created_by, created_at, updated_at
         FROM rooms WHERE id = $1
     `
     
@@ -52,6 +73,7 @@ func (r *RoomRepository) FindByID(id uuid.UUID) (*models.Room, error) {
         &room.Name,
         &room.Description,
         &room.Type,
+        &room.Visibility,
         &room.CreatedBy,
         &room.CreatedAt,
         &room.UpdatedAt,
@@ -64,13 +86,38 @@ func (r *RoomRepository) FindByID(id uuid.UUID) (*models.Room, error) {
     return room, err
 }
 
-func (r *RoomRepository) GetUserRooms(userID uuid.UUID) ([]*models.Room, error) {
+// GetUserRooms returns the user's rooms with their unread count and last
+// message, most recently active first
+func (r *RoomRepository) GetUserRooms(userID uuid.UUID) ([]*models.RoomSummary, error) {
     query := `
-        SELECT r.id, r.name, r.description, r.type, r.created_by, r.created_at, r.updated_at
+        SELECT r.id, r.name, r.description, r.type, r.visibility, r.created_by, r.created_at, r.updated_at,
+               COALESCE(unread.count, 0),
+               lm.id, lm.sender_id, lm.content, lm.type, lm.file_name, lm.seq, lm.created_at, lm.updated_at,
+               lu.username, lu.avatar_url,
+               COALESCE(lm.created_at, r.created_at) AS last_activity
         FROM rooms r
         JOIN room_members rm ON r.id = rm.room_id
+        LEFT JOIN LATERAL (
+            SELECT m.id, m.sender_id, m.content, m.type, m.file_name, m.seq, m.created_at, m.updated_at
+            FROM messages m
+            WHERE m.room_id = r.id
+            ORDER BY m.created_at DESC, m.id DESC
+            LIMIT 1
+        ) lm ON true
+        LEFT JOIN users lu ON lu.id = lm.sender_id
+        LEFT JOIN LATERAL (
+            SELECT COUNT(*) AS count
+            FROM messages m
+            WHERE m.room_id = r.id
+            AND m.sender_id <> $1
+            AND m.content != '[DELETED]'
+            AND NOT EXISTS (
+                SELECT 1 FROM message_read_status mrs
+                WHERE mrs.message_id = m.id AND mrs.user_id = $1
+            )
+        ) unread ON true
         WHERE rm.user_id = $1
-        ORDER BY r.updated_at DESC
+        ORDER BY last_activity DESC
     `
     
     rows, err := r.db.Query(query, userID)
@@ -79,25 +126,70 @@ func (r *RoomRepository) GetUserRooms(userID uuid.UUID) ([]*models.Room, error)
     }
     defer rows.Close()
     
-    var rooms []*models.Room
+    rooms := []*models.RoomSummary{}
     for rows.Next() {
-        room := &models.Room{}
+        room := &models.RoomSummary{}
+
+        var lastID, lastSenderID uuid.NullUUID
+        var lastContent, lastType, lastFileName, lastUsername, lastAvatarURL sql.NullString
+        var lastSeq sql.NullInt64
+        var lastCreatedAt, lastUpdatedAt sql.NullTime
+
         err := rows.Scan(
             &room.ID,
             &room.Name,
             &room.Description,
             &room.Type,
+            &room.Visibility,
             &room.CreatedBy,
             &room.CreatedAt,
             &room.UpdatedAt,
+            &room.UnreadCount,
+            &lastID,
+            &lastSenderID,
+            &lastContent,
+            &lastType,
+            &lastFileName,
+            &lastSeq,
+            &lastCreatedAt,
+            &lastUpdatedAt,
+            &lastUsername,
+            &lastAvatarURL,
+            &room.LastActivityAt,
         )
         if err != nil {
             return nil, err
         }
+
+        if lastID.Valid {
+            room.LastMessage = &models.Message{
+                ID:        lastID.UUID,
+                RoomID:    room.ID,
+                SenderID:  lastSenderID.UUID,
+                Content:   lastContent.String,
+                Type:      lastType.String,
+                Seq:       lastSeq.Int64,
+                CreatedAt: lastCreatedAt.Time,
+                UpdatedAt: lastUpdatedAt.Time,
+                IsEdited:  lastUpdatedAt.Time.After(lastCreatedAt.Time),
+                IsDeleted: lastContent.String == "[DELETED]",
+                Sender: &models.User{
+                    ID:       lastSenderID.UUID,
+                    Username: lastUsername.String,
+                },
+            }
+            if lastFileName.Valid {
+                room.LastMessage.FileName = &lastFileName.String
+            }
+            if lastAvatarURL.Valid {
+                room.LastMessage.Sender.AvatarURL = &lastAvatarURL.String
+            }
+        }
+
         rooms = append(rooms, room)
     }
     
-    return rooms, nil
+    return rooms, rows.Err()
 }
 
 func (r *RoomRepository) AddMember(roomID, userID uuid.UUID, role string) error {
@@ -120,10 +212,10 @@ func (r *RoomRepository) RemoveMember(roomID, userID uuid.UUID) error {
 func (r *RoomRepository) Update(room *models.Room) error {
     query := `
         UPDATE rooms 
-        SET name = $1, description = $2, updated_at = $3
-        WHERE id = $4
+        SET name = $1, description = $2, visibility = $3, updated_at = $4
+        WHERE id = $5
     `
-    _, err := r.db.Exec(query, room.Name, room.Description, time.Now(), room.ID)
+    _, err := r.db.Exec(query, room.Name, room.Description, room.Visibility, time.Now(), room.ID)
     return err
 }
 
@@ -140,12 +232,40 @@ func (r *RoomRepository) IsMember(roomID, userID uuid.UUID) (bool, error) {
     return exists, err
 }
 
-func (r *RoomRepository) GetMembers(roomID uuid.UUID) ([]*models.User, error) {
+// RoomPeerIDs returns every other user who shares a room with userID
+func (r *RoomRepository) RoomPeerIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+    query := `
+        SELECT DISTINCT peer.user_id
+        FROM room_members self
+        JOIN room_members peer ON peer.room_id = self.room_id
+        WHERE self.user_id = $1 AND peer.user_id <> $1
+    `
+
+    rows, err := r.db.Query(query, userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var peers []uuid.UUID
+    for rows.Next() {
+        var peerID uuid.UUID
+        if err := rows.Scan(&peerID); err != nil {
+            return nil, err
+        }
+        peers = append(peers, peerID)
+    }
+
+    return peers, rows.Err()
+}
+
+func (r *RoomRepository) GetMembers(roomID uuid.UUID) ([]*models.Member, error) {
     query := `
-        SELECT u.id, u.username, u.email, u.avatar_url, u.status, u.last_seen
+        SELECT u.id, u.username, u.email, u.avatar_url, u.status, u.last_seen, rm.role, rm.joined_at
         FROM users u
         JOIN room_members rm ON u.id = rm.user_id
         WHERE rm.room_id = $1
+        ORDER BY rm.joined_at
     `
     
     rows, err := r.db.Query(query, roomID)
@@ -154,24 +274,163 @@ func (r *RoomRepository) GetMembers(roomID uuid.UUID) ([]*models.User, error) {
     }
     defer rows.Close()
     
-    var users []*models.User
+    var members []*models.Member
     for rows.Next() {
-        user := &models.User{}
+        member := &models.Member{}
         err := rows.Scan(
-            &user.ID,
-            &user.Username,
-            &user.Email,
-            &user.AvatarURL,
-            &user.Status,
-            &user.LastSeen,
+            &member.ID,
+            &member.Username,
+            &member.Email,
+            &member.AvatarURL,
+            &member.Status,
+            &member.LastSeen,
+            &member.Role,
+            &member.JoinedAt,
         )
         if err != nil {
             return nil, err
         }
-        users = append(users, user)
+        members = append(members, member)
     }
     
-    return users, nil
+    return members, nil
+}
+
+// GetMemberRole returns the user's role in the room, or an empty string if
+// they are not a member
+func (r *RoomRepository) GetMemberRole(roomID, userID uuid.UUID) (string, error) {
+    var role string
+    query := `SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2`
+    err := r.db.QueryRow(query, roomID, userID).Scan(&role)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return role, err
+}
+
+// UpdateMemberRole sets a member's role. Ownership changes hands through
+// TransferOwnership instead.
+func (r *RoomRepository) UpdateMemberRole(roomID, userID uuid.UUID, role string) error {
+    query := `UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3`
+    _, err := r.db.Exec(query, role, roomID, userID)
+    return err
+}
+
+// TransferOwnership makes toUserID the room's owner and demotes the
+// current owner to admin. It returns ErrNotMember if toUserID isn't in the
+// room and ErrRoleChanged if fromUserID no longer owns it.
+func (r *RoomRepository) TransferOwnership(roomID, fromUserID, toUserID uuid.UUID) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    // Serialize with members leaving, so the target can't go mid-transfer
+    if _, err := tx.Exec(`SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, roomID); err != nil {
+        return err
+    }
+
+    var found int
+    err = tx.QueryRow(`
+        SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2
+        FOR UPDATE
+    `, roomID, toUserID).Scan(&found)
+    if err == sql.ErrNoRows {
+        return ErrNotMember
+    }
+    if err != nil {
+        return err
+    }
+
+    // The owner is demoted before the target is promoted, since a room may
+    // only have one owner at a time; both must touch exactly one row or the
+    // whole transfer is rolled back
+    result, err := tx.Exec(`
+        UPDATE room_members SET role = $1
+        WHERE room_id = $2 AND user_id = $3 AND role = $4
+    `, models.RoleAdmin, roomID, fromUserID, models.RoleOwner)
+    if err != nil {
+        return err
+    }
+    if rows, err := result.RowsAffected(); err != nil {
+        return err
+    } else if rows != 1 {
+        return ErrRoleChanged
+    }
+
+    result, err = tx.Exec(`
+        UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3
+    `, models.RoleOwner, roomID, toUserID)
+    if err != nil {
+        return err
+    }
+    if rows, err := result.RowsAffected(); err != nil {
+        return err
+    } else if rows != 1 {
+        return ErrNotMember
+    }
+
+    return tx.Commit()
+}
+
+// LeaveRoom removes the user from the room. If they owned it, ownership
+// passes to the highest ranked, longest-standing remaining member, whose ID
+// is returned; a room left with no members is deleted.
+func (r *RoomRepository) LeaveRoom(roomID, userID uuid.UUID) (uuid.UUID, error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return uuid.Nil, err
+    }
+    defer tx.Rollback()
+
+    // Serialize with other members leaving the same room
+    if _, err := tx.Exec(`SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, roomID); err != nil {
+        return uuid.Nil, err
+    }
+
+    var role string
+    err = tx.QueryRow(`
+        DELETE FROM room_members WHERE room_id = $1 AND user_id = $2
+        RETURNING role
+    `, roomID, userID).Scan(&role)
+    if err == sql.ErrNoRows {
+        return uuid.Nil, nil
+    }
+    if err != nil {
+        return uuid.Nil, err
+    }
+
+    if role != models.RoleOwner {
+        return uuid.Nil, tx.Commit()
+    }
+
+    var successorID uuid.UUID
+    err = tx.QueryRow(`
+        SELECT user_id FROM room_members
+        WHERE room_id = $1
+        ORDER BY CASE role WHEN 'admin' THEN 1 WHEN 'moderator' THEN 2 ELSE 3 END, joined_at, id
+        LIMIT 1
+    `, roomID).Scan(&successorID)
+
+    if err == sql.ErrNoRows {
+        if _, err := tx.Exec(`DELETE FROM rooms WHERE id = $1`, roomID); err != nil {
+            return uuid.Nil, err
+        }
+        return uuid.Nil, tx.Commit()
+    }
+    if err != nil {
+        return uuid.Nil, err
+    }
+
+    _, err = tx.Exec(`
+        UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3
+    `, models.RoleOwner, roomID, successorID)
+    if err != nil {
+        return uuid.Nil, err
+    }
+
+    return successorID, tx.Commit()
 }
 
 // Find or create a private room between two users
@@ -210,7 +469,7 @@ func (r *RoomRepository) FindOrCreatePrivateRoom(user1ID, user2ID uuid.UUID, oth
     
     // Now check again if room exists (double-check pattern)
     query := `
-        SELECT r.id, r.name, r.description, r.type, r.created_by, r.created_at, r.updated_at
+        SELECT r.id, r.name, r.description, r.type, r.visibility, r.created_by, r.created_at, r.updated_at
         FROM rooms r
         WHERE r.type = 'private'
         AND r.id IN (
@@ -228,6 +487,7 @@ func (r *RoomRepository) FindOrCreatePrivateRoom(user1ID, user2ID uuid.UUID, oth
         &room.Name,
         &room.Description,
         &room.Type,
+        &room.Visibility,
         &room.CreatedBy,
         &room.CreatedAt,
         &room.UpdatedAt,
@@ -250,12 +510,13 @@ func (r *RoomRepository) FindOrCreatePrivateRoom(user1ID, user2ID uuid.UUID, oth
     }
     
     room = &models.Room{
-        ID:        uuid.New(),
-        Name:      roomName,
-        Type:      "private",
-        CreatedBy: user1ID,
-        CreatedAt: time.Now(),
-        UpdatedAt: time.Now(),
+        ID:         uuid.New(),
+        Name:       roomName,
+        Type:       "private",
+        Visibility: "private",
+        CreatedBy:  user1ID,
+        CreatedAt:  time.Now(),
+        UpdatedAt:  time.Now(),
     }
     
     // Insert room
@@ -273,7 +534,7 @@ func (r *RoomRepository) FindOrCreatePrivateRoom(user1ID, user2ID uuid.UUID, oth
         INSERT INTO room_members (id, room_id, user_id, role, joined_at)
         VALUES ($1, $2, $3, $4, $5)
     `
-    _, err = tx.Exec(insertMember, uuid.New(), room.ID, user1ID, "member", time.Now())
+    _, err = tx.Exec(insertMember, uuid.New(), room.ID, user1ID, models.RoleOwner, time.Now())
     if err != nil {
         return nil, err
     }
@@ -288,4 +549,228 @@ func (r *RoomRepository) FindOrCreatePrivateRoom(user1ID, user2ID uuid.UUID, oth
     }
     
     return room, nil
-}
\ No newline at end of file
+}
+// DiscoverRooms lists public group rooms whose name or description
+// contains search, most populated first, excluding rooms the user is
+// banned from
+func (r *RoomRepository) DiscoverRooms(userID uuid.UUID, search string, limit, offset int) ([]*models.PublicRoom, error) {
+    query := `
+        SELECT r.id, r.name, r.description, r.type, r.visibility, r.created_by, r.created_at, r.updated_at,
+               (SELECT COUNT(*) FROM room_members rm WHERE rm.room_id = r.id) AS member_count,
+               EXISTS(SELECT 1 FROM room_members rm WHERE rm.room_id = r.id AND rm.user_id = $1)
+        FROM rooms r
+        WHERE r.visibility = 'public'
+        AND ($2 = '' OR r.name ILIKE '%' || $2 || '%' OR r.description ILIKE '%' || $2 || '%')
+        AND NOT EXISTS (
+            SELECT 1 FROM room_bans b WHERE b.room_id = r.id AND b.user_id = $1
+        )
+        ORDER BY member_count DESC, r.created_at DESC, r.id
+        LIMIT $3 OFFSET $4
+    `
+
+    rows, err := r.db.Query(query, userID, escapeLike(search), limit, offset)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    rooms := []*models.PublicRoom{}
+    for rows.Next() {
+        room := &models.PublicRoom{}
+        err := rows.Scan(
+            &room.ID,
+            &room.Name,
+            &room.Description,
+            &room.Type,
+            &room.Visibility,
+            &room.CreatedBy,
+            &room.CreatedAt,
+            &room.UpdatedAt,
+            &room.MemberCount,
+            &room.IsMember,
+        )
+        if err != nil {
+            return nil, err
+        }
+        rooms = append(rooms, room)
+    }
+
+    return rooms, rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+    return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// JoinRoom adds the user to the room as a member unless they are banned.
+// joined is false if they were already a member.
+func (r *RoomRepository) JoinRoom(roomID, userID uuid.UUID) (joined bool, err error) {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return false, err
+    }
+    defer tx.Rollback()
+
+    if err := checkNotBanned(tx, roomID, userID); err != nil {
+        return false, err
+    }
+
+    result, err := tx.Exec(`
+        INSERT INTO room_members (id, room_id, user_id, role, joined_at)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (room_id, user_id) DO NOTHING
+    `, uuid.New(), roomID, userID, models.RoleMember, time.Now())
+    if err != nil {
+        return false, err
+    }
+
+    added, err := result.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+
+    return added > 0, tx.Commit()
+}
+
+// checkNotBanned returns ErrBanned if the user is banned from the room
+func checkNotBanned(tx *sql.Tx, roomID, userID uuid.UUID) error {
+    var banned bool
+    query := `SELECT EXISTS(SELECT 1 FROM room_bans WHERE room_id = $1 AND user_id = $2)`
+    if err := tx.QueryRow(query, roomID, userID).Scan(&banned); err != nil {
+        return err
+    }
+    if banned {
+        return ErrBanned
+    }
+    return nil
+}
+
+// BanUser blocks the user from joining the room, removing them if they
+// are a member and cancelling any pending invitation. Members can only be
+// banned by someone who outranks them; ranks are compared with both
+// membership rows locked, so a concurrent role change can't slip between
+// the check and the ban. It returns ErrRoleChanged if bannedBy is no longer
+// a member and ErrOutranked if they don't outrank userID.
+func (r *RoomRepository) BanUser(roomID, userID, bannedBy uuid.UUID, reason *string) error {
+    tx, err := r.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    // Rows are locked in user ID order, so two bans can't deadlock
+    rows, err := tx.Query(`
+        SELECT user_id, role FROM room_members
+        WHERE room_id = $1 AND user_id IN ($2, $3)
+        ORDER BY user_id
+        FOR UPDATE
+    `, roomID, userID, bannedBy)
+    if err != nil {
+        return err
+    }
+    roles := make(map[uuid.UUID]string, 2)
+    for rows.Next() {
+        var memberID uuid.UUID
+        var role string
+        if err := rows.Scan(&memberID, &role); err != nil {
+            rows.Close()
+            return err
+        }
+        roles[memberID] = role
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    if roles[bannedBy] == "" {
+        return ErrRoleChanged
+    }
+    if target := roles[userID]; target != "" && models.RoleRank(roles[bannedBy]) <= models.RoleRank(target) {
+        return ErrOutranked
+    }
+
+    now := time.Now()
+    _, err = tx.Exec(`
+        INSERT INTO room_bans (room_id, user_id, banned_by, reason, created_at)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (room_id, user_id) DO UPDATE SET banned_by = $3, reason = $4
+    `, roomID, userID, bannedBy, reason, now)
+    if err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(`DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`, roomID, userID); err != nil {
+        return err
+    }
+
+    _, err = tx.Exec(`
+        UPDATE room_invitations SET status = 'cancelled', responded_at = $1
+        WHERE room_id = $2 AND invitee_id = $3 AND status = 'pending'
+    `, now, roomID, userID)
+    if err != nil {
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// UnbanUser lifts a ban; it reports whether the user was banned
+func (r *RoomRepository) UnbanUser(roomID, userID uuid.UUID) (bool, error) {
+    result, err := r.db.Exec(`DELETE FROM room_bans WHERE room_id = $1 AND user_id = $2`, roomID, userID)
+    if err != nil {
+        return false, err
+    }
+
+    rows, err := result.RowsAffected()
+    return rows > 0, err
+}
+
+// GetBans lists the room's banned users, most recent first
+func (r *RoomRepository) GetBans(roomID uuid.UUID) ([]*models.RoomBan, error) {
+    query := `
+        SELECT b.room_id, b.user_id, u.username, b.banned_by, b.reason, b.created_at
+        FROM room_bans b
+        JOIN users u ON u.id = b.user_id
+        WHERE b.room_id = $1
+        ORDER BY b.created_at DESC
+    `
+
+    rows, err := r.db.Query(query, roomID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    bans := []*models.RoomBan{}
+    for rows.Next() {
+        ban := &models.RoomBan{}
+        var bannedBy uuid.NullUUID
+        err := rows.Scan(
+            &ban.RoomID,
+            &ban.UserID,
+            &ban.Username,
+            &bannedBy,
+            &ban.Reason,
+            &ban.CreatedAt,
+        )
+        if err != nil {
+            return nil, err
+        }
+        if bannedBy.Valid {
+            ban.BannedBy = &bannedBy.UUID
+        }
+        bans = append(bans, ban)
+    }
+
+    return bans, rows.Err()
+}
+
+// IsBanned reports whether the user is banned from the room
+func (r *RoomRepository) IsBanned(roomID, userID uuid.UUID) (bool, error) {
+    var banned bool
+    query := `SELECT EXISTS(SELECT 1 FROM room_bans WHERE room_id = $1 AND user_id = $2)`
+    err := r.db.QueryRow(query, roomID, userID).Scan(&banned)
+    return banned, err
+}
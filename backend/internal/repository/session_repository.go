@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/halizadz/chat-app-backend/internal/models"
+)
+
+var (
+	// ErrSessionNotFound means no session matches the refresh token
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrSessionExpired means the session was revoked or ran out
+	ErrSessionExpired = errors.New("session expired or revoked")
+
+	// ErrRefreshTokenReused means an already rotated refresh token was
+	// presented again, so the session is revoked as likely stolen
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+type SessionRepository struct {
+	db *sql.DB
+}
+
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// Create stores a new session for the given refresh token hash
+func (r *SessionRepository) Create(session *models.Session, tokenHash string) error {
+	query := `
+        INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address, expires_at, created_at, last_used_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING id, created_at, last_used_at
+    `
+
+	session.ID = uuid.New()
+	now := time.Now()
+
+	return r.db.QueryRow(
+		query,
+		session.ID,
+		session.UserID,
+		tokenHash,
+		session.UserAgent,
+		session.IPAddress,
+		session.ExpiresAt,
+		now,
+		now,
+	).Scan(&session.ID, &session.CreatedAt, &session.LastUsedAt)
+}
+
+// Rotate swaps the session's refresh token for a new one and extends its
+// expiry. Presenting the token that was rotated out revokes the session.
+func (r *SessionRepository) Rotate(tokenHash, newTokenHash string, expiresAt time.Time) (*models.Session, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	session := &models.Session{}
+	err = tx.QueryRow(`
+        SELECT id, user_id, COALESCE(user_agent, ''), COALESCE(ip_address, ''),
+               expires_at, revoked_at, created_at, last_used_at
+        FROM sessions WHERE refresh_token_hash = $1
+        FOR UPDATE
+    `, tokenHash).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IPAddress,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		// An old token coming back means someone else holds the new one
+		result, err := tx.Exec(`
+            UPDATE sessions SET revoked_at = NOW()
+            WHERE previous_token_hash = $1 AND revoked_at IS NULL
+        `, tokenHash)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			if err := tx.Commit(); err != nil {
+				return nil, err
+			}
+			return nil, ErrRefreshTokenReused
+		}
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(`
+        UPDATE sessions
+        SET refresh_token_hash = $1, previous_token_hash = $2, expires_at = $3, last_used_at = $4
+        WHERE id = $5
+    `, newTokenHash, tokenHash, expiresAt, now, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	session.ExpiresAt = expiresAt
+	session.LastUsedAt = now
+	return session, nil
+}
+
+// Revoke ends a session; access tokens bound to it stop working at once
+func (r *SessionRepository) Revoke(sessionID uuid.UUID) error {
+	query := `UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`
+	_, err := r.db.Exec(query, sessionID)
+	return err
+}
+
+// RevokeForUser ends one of the user's own sessions. It returns
+// ErrSessionNotFound if the session does not belong to the user or has
+// already ended.
+func (r *SessionRepository) RevokeForUser(sessionID, userID uuid.UUID) error {
+	query := `
+        UPDATE sessions SET revoked_at = NOW()
+        WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > $3
+    `
+	result, err := r.db.Exec(query, sessionID, userID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// GetActiveByUserID lists the user's live sessions, most recently used first
+func (r *SessionRepository) GetActiveByUserID(userID uuid.UUID) ([]*models.Session, error) {
+	query := `
+        SELECT id, user_id, COALESCE(user_agent, ''), COALESCE(ip_address, ''),
+               expires_at, revoked_at, created_at, last_used_at
+        FROM sessions
+        WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+        ORDER BY last_used_at DESC
+    `
+
+	rows, err := r.db.Query(query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*models.Session
+	for rows.Next() {
+		session := &models.Session{}
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.UserAgent,
+			&session.IPAddress,
+			&session.ExpiresAt,
+			&session.RevokedAt,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// IsActive reports whether the session exists, is not revoked and has not
+// expired
+func (r *SessionRepository) IsActive(sessionID uuid.UUID) (bool, error) {
+	var active bool
+	query := `
+        SELECT EXISTS(
+            SELECT 1 FROM sessions
+            WHERE id = $1 AND revoked_at IS NULL AND expires_at > $2
+        )
+    `
+	err := r.db.QueryRow(query, sessionID, time.Now()).Scan(&active)
+	return active, err
+}
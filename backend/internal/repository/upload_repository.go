@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/halizadz/chat-app-backend/internal/models"
+)
+
+var (
+	// ErrUploadNotFound means the user has no such upload, or it expired
+	ErrUploadNotFound = errors.New("upload not found")
+
+	// ErrUploadConflict means the upload moved on since it was read: another
+	// chunk landed at the same offset, or it is already being completed
+	ErrUploadConflict = errors.New("upload changed concurrently")
+)
+
+type UploadRepository struct {
+	db *sql.DB
+}
+
+func NewUploadRepository(db *sql.DB) *UploadRepository {
+	return &UploadRepository{db: db}
+}
+
+const uploadColumns = `id, user_id, file_name, size, received, checksum, hash_state, status, attachment_id, created_at, expires_at`
+
+func scanUpload(row rowScanner) (*models.Upload, error) {
+	upload := &models.Upload{}
+	err := row.Scan(
+		&upload.ID,
+		&upload.UserID,
+		&upload.FileName,
+		&upload.Size,
+		&upload.Received,
+		&upload.Checksum,
+		&upload.HashState,
+		&upload.Status,
+		&upload.AttachmentID,
+		&upload.CreatedAt,
+		&upload.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrUploadNotFound
+	}
+	return upload, err
+}
+
+func (r *UploadRepository) Create(upload *models.Upload) error {
+	query := `
+        INSERT INTO uploads (id, user_id, file_name, size, checksum, expires_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING received, status, created_at
+    `
+
+	upload.ID = uuid.New()
+	return r.db.QueryRow(
+		query,
+		upload.ID,
+		upload.UserID,
+		upload.FileName,
+		upload.Size,
+		upload.Checksum,
+		upload.ExpiresAt,
+		time.Now(),
+	).Scan(&upload.Received, &upload.Status, &upload.CreatedAt)
+}
+
+// FindForUser returns one of the user's uploads that has not expired
+func (r *UploadRepository) FindForUser(id, userID uuid.UUID) (*models.Upload, error) {
+	query := `SELECT ` + uploadColumns + ` FROM uploads WHERE id = $1 AND user_id = $2 AND expires_at > $3`
+	return scanUpload(r.db.QueryRow(query, id, userID, time.Now()))
+}
+
+// AppendChunk records a stored chunk starting at position and moves the
+// upload on past it. It returns ErrUploadConflict unless the upload is
+// still pending with exactly position bytes received.
+func (r *UploadRepository) AppendChunk(id uuid.UUID, chunk models.UploadChunk, hashState []byte, expiresAt time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        UPDATE uploads SET received = received + $1, hash_state = $2, expires_at = $3
+        WHERE id = $4 AND received = $5 AND status = 'pending'
+    `, chunk.Size, hashState, expiresAt, id, chunk.Position)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUploadConflict
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO upload_chunks (upload_id, position, size, storage_key)
+        VALUES ($1, $2, $3, $4)
+    `, id, chunk.Position, chunk.Size, chunk.StorageKey)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetChunks lists an upload's chunks in order
+func (r *UploadRepository) GetChunks(id uuid.UUID) ([]models.UploadChunk, error) {
+	rows, err := r.db.Query(`
+        SELECT position, size, storage_key FROM upload_chunks
+        WHERE upload_id = $1
+        ORDER BY position
+    `, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []models.UploadChunk
+	for rows.Next() {
+		var chunk models.UploadChunk
+		if err := rows.Scan(&chunk.Position, &chunk.Size, &chunk.StorageKey); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, rows.Err()
+}
+
+// BeginComplete claims a fully received upload for completion, so two
+// requests can't assemble it at once. expiresAt should leave time to finish.
+func (r *UploadRepository) BeginComplete(id, userID uuid.UUID, expiresAt time.Time) error {
+	result, err := r.db.Exec(`
+        UPDATE uploads SET status = 'processing', expires_at = $1
+        WHERE id = $2 AND user_id = $3 AND status = 'pending' AND received = size
+    `, expiresAt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUploadConflict
+	}
+	return nil
+}
+
+// AbortComplete returns an upload claimed by BeginComplete to pending, so
+// completing it can be retried
+func (r *UploadRepository) AbortComplete(id uuid.UUID) error {
+	_, err := r.db.Exec(`UPDATE uploads SET status = 'pending' WHERE id = $1 AND status = 'processing'`, id)
+	return err
+}
+
+// FinishComplete marks the upload completed and forgets its chunks, whose
+// objects the caller has removed. The upload itself is kept until it
+// expires so a retried request can find the attachment.
+func (r *UploadRepository) FinishComplete(id, attachmentID uuid.UUID) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        UPDATE uploads SET status = 'completed', attachment_id = $1, hash_state = NULL
+        WHERE id = $2
+    `, attachmentID, id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM upload_chunks WHERE upload_id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes one of the user's uploads that isn't being completed and
+// returns the storage keys of its chunks
+func (r *UploadRepository) Delete(id, userID uuid.UUID) ([]string, error) {
+	query := `
+        WITH deleted AS (
+            DELETE FROM uploads
+            WHERE id = $1 AND user_id = $2 AND status <> 'processing'
+            RETURNING id
+        )
+        SELECT d.id, c.storage_key
+        FROM deleted d
+        LEFT JOIN upload_chunks c ON c.upload_id = d.id
+    `
+	keys, deleted, err := r.deleteReturningKeys(query, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if deleted == 0 {
+		return nil, ErrUploadNotFound
+	}
+	return keys, nil
+}
+
+// DeleteExpired removes up to limit expired uploads and returns the storage
+// keys of their chunks. Rows locked by another replica are skipped.
+func (r *UploadRepository) DeleteExpired(limit int) ([]string, int, error) {
+	query := `
+        WITH deleted AS (
+            DELETE FROM uploads
+            WHERE id IN (
+                SELECT id FROM uploads
+                WHERE expires_at <= $1
+                ORDER BY expires_at
+                LIMIT $2
+                FOR UPDATE SKIP LOCKED
+            )
+            RETURNING id
+        )
+        SELECT d.id, c.storage_key
+        FROM deleted d
+        LEFT JOIN upload_chunks c ON c.upload_id = d.id
+    `
+	return r.deleteReturningKeys(query, time.Now(), limit)
+}
+
+// deleteReturningKeys runs a query deleting uploads that returns each
+// deleted upload's ID and chunk storage keys. It returns the keys and how
+// many uploads were deleted.
+func (r *UploadRepository) deleteReturningKeys(query string, args ...interface{}) ([]string, int, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	deleted := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		var key sql.NullString
+		if err := rows.Scan(&id, &key); err != nil {
+			return nil, 0, err
+		}
+		deleted[id] = true
+		if key.Valid {
+			keys = append(keys, key.String)
+		}
+	}
+
+	return keys, len(deleted), rows.Err()
+}
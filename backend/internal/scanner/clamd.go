@@ -0,0 +1,130 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Size of the chunks streamed to clamd; well under its default
+// StreamMaxLength so a single chunk is never refused
+const clamdChunkSize = 64 << 10
+
+// ClamdScanner streams files to a clamd daemon with the INSTREAM command
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner connects to clamd at address, either tcp://host:port or
+// unix:///path/to/clamd.sock; a bare host:port is taken as TCP. timeout
+// bounds each write to clamd and the wait for its verdict, not the scan
+// as a whole, so large files don't need a large timeout.
+func NewClamdScanner(address string, timeout time.Duration) (*ClamdScanner, error) {
+	network := "tcp"
+	if rest, ok := strings.CutPrefix(address, "unix://"); ok {
+		network, address = "unix", rest
+	} else {
+		address = strings.TrimPrefix(address, "tcp://")
+	}
+	if address == "" {
+		return nil, errors.New("clamd address is required")
+	}
+
+	return &ClamdScanner{network: network, address: address, timeout: timeout}, nil
+}
+
+func (s *ClamdScanner) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	// Abort a scan in progress when the request goes away
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if err := s.write(conn, []byte("zINSTREAM\x00")); err != nil {
+		return nil, err
+	}
+
+	// Each chunk is sent with its length; a zero length ends the stream
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, readErr := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if err := s.write(conn, buf[:4+n]); err != nil {
+				// clamd hangs up on streams over its limit, after saying why
+				if reply, replyErr := s.reply(conn); replyErr == nil {
+					return parseReply(reply)
+				}
+				return nil, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+
+	if err := s.write(conn, []byte{0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+
+	reply, err := s.reply(conn)
+	if err != nil {
+		return nil, err
+	}
+	return parseReply(reply)
+}
+
+func (s *ClamdScanner) write(conn net.Conn, data []byte) error {
+	if s.timeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	}
+	if _, err := conn.Write(data); err != nil {
+		return fmt.Errorf("error sending to clamd: %w", err)
+	}
+	return nil
+}
+
+// reply reads clamd's NUL-terminated response
+func (s *ClamdScanner) reply(conn net.Conn) (string, error) {
+	if s.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.timeout))
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return "", fmt.Errorf("error reading clamd reply: %w", err)
+	}
+	return strings.TrimSuffix(reply, "\x00"), nil
+}
+
+// parseReply reads a verdict like "stream: OK" or
+// "stream: Eicar-Signature FOUND"
+func parseReply(reply string) (*Result, error) {
+	verdict := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+
+	switch {
+	case verdict == "OK":
+		return &Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return &Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return nil, fmt.Errorf("clamd: %s", verdict)
+	}
+}
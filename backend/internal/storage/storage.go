@@ -0,0 +1,87 @@
+// Package storage keeps uploaded files behind a common interface so they
+// can live on local disk for development or in an S3-compatible bucket
+// shared by every replica.
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound means no object is stored under the key
+	ErrNotFound = errors.New("object not found")
+
+	// ErrInvalidKey means the key is empty or escapes the storage root
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Object describes a stored file
+type Object struct {
+	Size        int64
+	ContentType string // Empty if the backend doesn't know it
+	ModTime     time.Time
+}
+
+// Storage stores files under slash-separated keys
+type Storage interface {
+	// Put stores size bytes from r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Get opens the object stored under key. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+
+	// Delete removes the object; deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+
+	// SignedURL returns a URL that fetches the object without further
+	// authentication until it expires
+	SignedURL(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// ValidateKey rejects keys that are empty, absolute or contain ".."
+// segments, so they can be mapped safely onto paths and URLs
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key {
+		return ErrInvalidKey
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// Signer creates and checks expiring signatures for URLs the server itself
+// serves
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret string) *Signer {
+	return &Signer{secret: []byte(secret)}
+}
+
+// Sign returns the signature for value valid until expires
+func (s *Signer) Sign(value string, expires time.Time) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%d", value, expires.Unix())
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature was issued for value and has not expired
+func (s *Signer) Verify(value string, expires time.Time, signature string) bool {
+	if time.Now().After(expires) {
+		return false
+	}
+	return hmac.Equal([]byte(s.Sign(value, expires)), []byte(signature))
+}
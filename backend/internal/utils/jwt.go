@@ -9,19 +9,23 @@ import (
 )
 
 type Claims struct {
-    UserID   uuid.UUID `json:"user_id"`
-    Username string    `json:"username"`
-    Email    string    `json:"email"`
+    UserID    uuid.UUID `json:"user_id"`
+    Username  string    `json:"username"`
+    Email     string    `json:"email"`
+    SessionID uuid.UUID `json:"sid"`
     jwt.RegisteredClaims
 }
 
-func GenerateToken(userID uuid.UUID, username, email, secret string) (string, error) {
+// GenerateToken issues a short-lived access token bound to a session, so
+// revoking the session invalidates the token before it expires
+func GenerateToken(userID uuid.UUID, username, email string, sessionID uuid.UUID, ttl time.Duration, secret string) (string, error) {
     claims := &Claims{
-        UserID:   userID,
-        Username: username,
-        Email:    email,
+        UserID:    userID,
+        Username:  username,
+        Email:     email,
+        SessionID: sessionID,
         RegisteredClaims: jwt.RegisteredClaims{
-            ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+            ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
             IssuedAt:  jwt.NewNumericDate(time.Now()),
         },
     }
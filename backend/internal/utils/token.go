@@ -0,0 +1,34 @@
+package utils
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+)
+
+// GenerateRefreshToken returns a random opaque token and the hash to store
+func GenerateRefreshToken() (token string, hash string, err error) {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        return "", "", err
+    }
+
+    token = base64.RawURLEncoding.EncodeToString(b)
+    return token, HashToken(token), nil
+}
+
+// HashToken hashes a refresh token for storage and lookup
+func HashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// GenerateInviteCode returns a short random code for invite links
+func GenerateInviteCode() (string, error) {
+    b := make([]byte, 9)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
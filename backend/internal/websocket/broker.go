@@ -0,0 +1,111 @@
+package websocket
+
+import (
+    "errors"
+    "sync"
+)
+
+// Broker carries hub events between server replicas. Every event a hub
+// publishes is delivered to every subscriber, including the publishing
+// hub itself, so a hub only ever writes to sockets from its subscription.
+type Broker interface {
+    // Publish sends payload to all subscribers
+    Publish(payload []byte) error
+
+    // Subscribe returns a channel of payloads published by any replica
+    Subscribe() (<-chan []byte, error)
+
+    // Close releases the broker's connections and closes subscriptions
+    Close() error
+}
+
+// ErrBrokerClosed is returned when publishing on a closed broker
+var ErrBrokerClosed = errors.New("broker closed")
+
+// MemoryBroker is an in-process Broker for single-node deployments and
+// tests. Each subscriber gets its own unbounded queue, so a slow hub never
+// makes the broker drop events or block publishers.
+type MemoryBroker struct {
+    mu     sync.RWMutex
+    subs   []chan []byte
+    closed bool
+}
+
+func NewMemoryBroker() *MemoryBroker {
+    return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(payload []byte) error {
+    b.mu.RLock()
+    defer b.mu.RUnlock()
+
+    if b.closed {
+        return ErrBrokerClosed
+    }
+
+    // Every queue is always ready to receive, so this never blocks for long
+    for _, sub := range b.subs {
+        sub <- payload
+    }
+    return nil
+}
+
+func (b *MemoryBroker) Subscribe() (<-chan []byte, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.closed {
+        return nil, ErrBrokerClosed
+    }
+
+    in := make(chan []byte)
+    out := make(chan []byte)
+    b.subs = append(b.subs, in)
+    go queue(in, out)
+    return out, nil
+}
+
+func (b *MemoryBroker) Close() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.closed {
+        return nil
+    }
+    b.closed = true
+
+    for _, sub := range b.subs {
+        close(sub)
+    }
+    b.subs = nil
+    return nil
+}
+
+// queue forwards payloads from in to out in order, holding as many as out's
+// reader falls behind by. out is closed once in is closed; anything still
+// queued at that point is discarded.
+func queue(in <-chan []byte, out chan<- []byte) {
+    defer close(out)
+
+    var pending [][]byte
+    for {
+        // A nil channel never fires, so nothing is sent while pending is empty
+        var send chan<- []byte
+        var next []byte
+        if len(pending) > 0 {
+            send = out
+            next = pending[0]
+        }
+
+        select {
+        case payload, ok := <-in:
+            if !ok {
+                return
+            }
+            pending = append(pending, payload)
+        case send <- next:
+            pending[0] = nil
+            pending = pending[1:]
+        }
+    }
+}
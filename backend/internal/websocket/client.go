@@ -10,7 +10,13 @@ import (
 )
 
 type Client struct {
-    ID       uuid.UUID
+    // ID identifies this connection; a user may hold several at once
+    ID     uuid.UUID
+    UserID uuid.UUID
+
+    // SessionID is the login session whose token opened the connection
+    SessionID uuid.UUID
+
     Hub      *Hub
     Conn     *websocket.Conn
     Send     chan []byte
@@ -18,14 +24,16 @@ type Client struct {
     Username string
 }
 
-func NewClient(hub *Hub, conn *websocket.Conn, userID uuid.UUID, username string) *Client {
+func NewClient(hub *Hub, conn *websocket.Conn, userID, sessionID uuid.UUID, username string) *Client {
     return &Client{
-        ID:       userID,
-        Hub:      hub,
-        Conn:     conn,
-        Send:     make(chan []byte, 256),
-        Rooms:    make(map[uuid.UUID]bool),
-        Username: username,
+        ID:        uuid.New(),
+        UserID:    userID,
+        SessionID: sessionID,
+        Hub:       hub,
+        Conn:      conn,
+        Send:      make(chan []byte, 256),
+        Rooms:     make(map[uuid.UUID]bool),
+        Username:  username,
     }
 }
 
@@ -59,7 +67,7 @@ func (c *Client) ReadPump() {
             continue
         }
 
-        msg.SenderID = c.ID
+        msg.SenderID = c.UserID
         msg.Username = c.Username
         msg.Timestamp = time.Now()
 
@@ -10,10 +10,10 @@ import (
 )
 
 type Hub struct {
-    // Registered clients
-    Clients map[uuid.UUID]*Client
+    // Registered connections, grouped by user ID and then connection ID
+    Clients map[uuid.UUID]map[uuid.UUID]*Client
 
-    // Client rooms mapping
+    // Connections subscribed to each room, keyed by connection ID
     Rooms map[uuid.UUID]map[uuid.UUID]*Client
 
     // Inbound messages from clients
@@ -28,12 +28,33 @@ type Hub struct {
     // Typing indicators
     Typing chan *TypingIndicator
 
+    // broker fans room events out to every replica, including this one
+    broker Broker
+
+    // presence is notified as users come and go; nil disables it
+    presence *Presence
+
     mu sync.RWMutex
 }
 
-func NewHub() *Hub {
+// event is the envelope the hub exchanges with its Broker. It targets
+// either every connection in RoomID or every connection of UserIDs. When
+// CloseSessionID is set, the connections of that session receive the
+// payload and are then closed instead. When Unsubscribe is set, the
+// targeted connections receive the payload and then leave RoomID.
+type event struct {
+    RoomID         uuid.UUID       `json:"room_id"`
+    UserIDs        []uuid.UUID     `json:"user_ids,omitempty"`
+    ExcludeUserID  uuid.UUID       `json:"exclude_user_id"`
+    CloseSessionID uuid.UUID       `json:"close_session_id,omitzero"`
+    Unsubscribe    bool            `json:"unsubscribe,omitempty"`
+    Payload        json.RawMessage `json:"payload"`
+}
+
+func NewHub(broker Broker) *Hub {
     return &Hub{
-        Clients:    make(map[uuid.UUID]*Client),
+        broker:     broker,
+        Clients:    make(map[uuid.UUID]map[uuid.UUID]*Client),
         Rooms:      make(map[uuid.UUID]map[uuid.UUID]*Client),
         Broadcast:  make(chan *Message, 256),
         Register:   make(chan *Client),
@@ -43,87 +64,262 @@ func NewHub() *Hub {
 }
 
 func (h *Hub) Run() {
+    events, err := h.broker.Subscribe()
+    if err != nil {
+        // A nil channel never fires, so publish falls back to local delivery
+        log.Printf("error subscribing to broker, delivering locally only: %v", err)
+    }
+
     for {
         select {
-        case client := <-h.Register:
+        case data, ok := <-events:
+            if !ok {
+                log.Printf("Broker subscription closed, delivering locally only")
+                events = nil
+                continue
+            }
+
+            var ev event
+            if err := json.Unmarshal(data, &ev); err != nil {
+                log.Printf("error unmarshaling broker event: %v", err)
+                continue
+            }
+
             h.mu.Lock()
-            h.Clients[client.ID] = client
-            log.Printf("Client registered: %s (%s)", client.Username, client.ID)
+            h.deliver(&ev)
             h.mu.Unlock()
 
+        case client := <-h.Register:
+            h.RegisterClient(client)
+
         case client := <-h.Unregister:
             h.mu.Lock()
-            if _, ok := h.Clients[client.ID]; ok {
-                // Remove from all rooms
-                for roomID := range client.Rooms {
-                    h.leaveRoom(client, roomID)
-                }
-                
-                delete(h.Clients, client.ID)
-                close(client.Send)
-                log.Printf("Client unregistered: %s (%s)", client.Username, client.ID)
-            }
+            h.removeClient(client)
             h.mu.Unlock()
 
         case message := <-h.Broadcast:
-            h.mu.RLock()
-            if message.Type == "message" || message.Type == "file" {
-                // Send to all clients in the room
-                if room, ok := h.Rooms[message.RoomID]; ok {
-                    messageBytes, err := json.Marshal(message)
-                    if err != nil {
-                        log.Printf("error marshaling message: %v", err)
-                        h.mu.RUnlock()
-                        continue
-                    }
-
-                    for clientID, client := range room {
-                        select {
-                        case client.Send <- messageBytes:
-                        default:
-                            close(client.Send)
-                            delete(h.Clients, clientID)
-                            delete(room, clientID)
-                        }
-                    }
-                }
+            if message.Type != "message" && message.Type != "file" && message.Type != "image" {
+                continue
             }
-            h.mu.RUnlock()
+
+            messageBytes, err := json.Marshal(message)
+            if err != nil {
+                log.Printf("error marshaling message: %v", err)
+                continue
+            }
+
+            // Send to every connection in the room, on every replica
+            h.publish(&event{RoomID: message.RoomID, Payload: messageBytes})
 
         case typing := <-h.Typing:
-            h.mu.RLock()
-            if room, ok := h.Rooms[typing.RoomID]; ok {
-                typingBytes, err := json.Marshal(map[string]interface{}{
-                    "type":      "typing",
-                    "room_id":   typing.RoomID,
-                    "user_id":   typing.UserID,
-                    "username":  typing.Username,
-                    "is_typing": typing.IsTyping,
-                })
-                if err != nil {
-                    log.Printf("error marshaling typing indicator: %v", err)
-                    h.mu.RUnlock()
-                    continue
-                }
-
-                for clientID, client := range room {
-                    // Don't send typing indicator to the typer
-                    if clientID != typing.UserID {
-                        select {
-                        case client.Send <- typingBytes:
-                        default:
-                            close(client.Send)
-                            delete(h.Clients, clientID)
-                            delete(room, clientID)
-                        }
-                    }
-                }
+            typingBytes, err := json.Marshal(map[string]interface{}{
+                "type":      "typing",
+                "room_id":   typing.RoomID,
+                "user_id":   typing.UserID,
+                "username":  typing.Username,
+                "is_typing": typing.IsTyping,
+            })
+            if err != nil {
+                log.Printf("error marshaling typing indicator: %v", err)
+                continue
+            }
+
+            // Don't send typing indicator to any of the typer's devices
+            h.publish(&event{RoomID: typing.RoomID, ExcludeUserID: typing.UserID, Payload: typingBytes})
+        }
+    }
+}
+
+// RegisterClient adds a connection to the hub. Unlike sending on Register,
+// it returns only once the connection is registered, so payloads can be
+// queued for it straight away.
+func (h *Hub) RegisterClient(client *Client) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    conns, ok := h.Clients[client.UserID]
+    if !ok {
+        conns = make(map[uuid.UUID]*Client)
+        h.Clients[client.UserID] = conns
+    }
+    conns[client.ID] = client
+    log.Printf("Client registered: %s (user %s, connection %s, %d active)", client.Username, client.UserID, client.ID, len(conns))
+    if len(conns) == 1 && h.presence != nil {
+        h.presence.connected(client.UserID, client.Username)
+    }
+}
+
+// BroadcastToRoom sends v to every connection subscribed to the room, on
+// every replica. It is safe to call from any goroutine.
+func (h *Hub) BroadcastToRoom(roomID uuid.UUID, v interface{}) {
+    payload, err := json.Marshal(v)
+    if err != nil {
+        log.Printf("error marshaling room event: %v", err)
+        return
+    }
+
+    h.publish(&event{RoomID: roomID, Payload: payload})
+}
+
+// SendToUsers sends v to every connection of the given users, on every
+// replica. It is safe to call from any goroutine.
+func (h *Hub) SendToUsers(userIDs []uuid.UUID, v interface{}) {
+    if len(userIDs) == 0 {
+        return
+    }
+
+    payload, err := json.Marshal(v)
+    if err != nil {
+        log.Printf("error marshaling user event: %v", err)
+        return
+    }
+
+    h.publish(&event{UserIDs: userIDs, Payload: payload})
+}
+
+// CloseSession disconnects every connection opened with the session's
+// tokens, on every replica. userID is the session's owner.
+func (h *Hub) CloseSession(userID, sessionID uuid.UUID) {
+    payload, err := json.Marshal(SessionRevoked{Type: "session_revoked", SessionID: sessionID})
+    if err != nil {
+        log.Printf("error marshaling session event: %v", err)
+        return
+    }
+
+    h.publish(&event{UserIDs: []uuid.UUID{userID}, CloseSessionID: sessionID, Payload: payload})
+}
+
+// RemoveFromRoom tells the users they no longer belong to the room and
+// unsubscribes their connections from it, on every replica. With no users,
+// every connection in the room is removed, as when the room is deleted.
+func (h *Hub) RemoveFromRoom(roomID uuid.UUID, userIDs ...uuid.UUID) {
+    payload, err := json.Marshal(RoomRemoved{Type: "room_removed", RoomID: roomID})
+    if err != nil {
+        log.Printf("error marshaling room removal: %v", err)
+        return
+    }
+
+    h.publish(&event{RoomID: roomID, UserIDs: userIDs, Unsubscribe: true, Payload: payload})
+}
+
+// publish hands an event to the broker. If the broker is unavailable the
+// event is still delivered to this replica's own connections.
+func (h *Hub) publish(ev *event) {
+    data, err := json.Marshal(ev)
+    if err != nil {
+        log.Printf("error marshaling broker event: %v", err)
+        return
+    }
+
+    if err := h.broker.Publish(data); err != nil {
+        log.Printf("error publishing to broker, delivering locally: %v", err)
+        h.mu.Lock()
+        h.deliver(ev)
+        h.mu.Unlock()
+    }
+}
+
+// deliver queues the event's payload for every connection it targets on
+// this replica, skipping connections owned by ExcludeUserID. Connections
+// whose send buffer is full are dropped. Callers must hold h.mu for writing.
+func (h *Hub) deliver(ev *event) {
+    var targets []*Client
+    if len(ev.UserIDs) > 0 {
+        for _, userID := range ev.UserIDs {
+            for _, client := range h.Clients[userID] {
+                targets = append(targets, client)
+            }
+        }
+    } else {
+        for _, client := range h.Rooms[ev.RoomID] {
+            targets = append(targets, client)
+        }
+    }
+
+    if ev.CloseSessionID != uuid.Nil {
+        for _, client := range targets {
+            if client.SessionID != ev.CloseSessionID {
+                continue
+            }
+            // The payload is flushed before WritePump sees the closed channel
+            select {
+            case client.Send <- ev.Payload:
+            default:
+            }
+            h.removeClient(client)
+        }
+        return
+    }
+
+    if ev.Unsubscribe {
+        for _, client := range targets {
+            select {
+            case client.Send <- ev.Payload:
+            default:
             }
-            h.mu.RUnlock()
+            h.leaveRoom(client, ev.RoomID)
+        }
+        return
+    }
+
+    var slow []*Client
+    for _, client := range targets {
+        if ev.ExcludeUserID != uuid.Nil && client.UserID == ev.ExcludeUserID {
+            continue
+        }
+        select {
+        case client.Send <- ev.Payload:
+        default:
+            slow = append(slow, client)
+        }
+    }
+
+    for _, client := range slow {
+        h.removeClient(client)
+    }
+}
+
+// removeClient drops a connection from the hub and closes its send channel.
+// The user stays online while any of their other connections remain.
+// Callers must hold h.mu for writing.
+func (h *Hub) removeClient(client *Client) {
+    conns, ok := h.Clients[client.UserID]
+    if !ok || conns[client.ID] != client {
+        return
+    }
+
+    // Remove from all rooms
+    for roomID := range client.Rooms {
+        h.leaveRoom(client, roomID)
+    }
+
+    delete(conns, client.ID)
+    close(client.Send)
+    log.Printf("Client unregistered: %s (user %s, connection %s)", client.Username, client.UserID, client.ID)
+
+    if len(conns) == 0 {
+        delete(h.Clients, client.UserID)
+        log.Printf("User %s has no active connections left", client.UserID)
+        if h.presence != nil {
+            h.presence.disconnected(client.UserID)
         }
     }
 }
 
+// Touch records activity on a connection for presence tracking
+func (h *Hub) Touch(client *Client) {
+    if h.presence != nil {
+        h.presence.touch(client.UserID)
+    }
+}
+
+// IsOnline reports whether the user has at least one active connection
+func (h *Hub) IsOnline(userID uuid.UUID) bool {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    return len(h.Clients[userID]) > 0
+}
+
 func (h *Hub) JoinRoom(client *Client, roomID uuid.UUID) {
     h.mu.Lock()
     defer h.mu.Unlock()
@@ -141,12 +337,12 @@ func (h *Hub) JoinRoom(client *Client, roomID uuid.UUID) {
     joinMsg := &Message{
         Type:      "join",
         RoomID:    roomID,
-        SenderID:  client.ID,
+        SenderID:  client.UserID,
         Username:  client.Username,
         Content:   client.Username + " joined the room",
         Timestamp: time.Now(),
     }
-    h.Broadcast <- joinMsg
+    h.enqueue(joinMsg)
 }
 
 func (h *Hub) leaveRoom(client *Client, roomID uuid.UUID) {
@@ -164,12 +360,22 @@ func (h *Hub) leaveRoom(client *Client, roomID uuid.UUID) {
         leaveMsg := &Message{
             Type:      "leave",
             RoomID:    roomID,
-            SenderID:  client.ID,
+            SenderID:  client.UserID,
             Username:  client.Username,
             Content:   client.Username + " left the room",
             Timestamp: time.Now(),
         }
-        h.Broadcast <- leaveMsg
+        h.enqueue(leaveMsg)
+    }
+}
+
+// enqueue hands a message to Run without blocking. It is used from code
+// that already holds h.mu, where a blocking send could deadlock with Run.
+func (h *Hub) enqueue(message *Message) {
+    select {
+    case h.Broadcast <- message:
+    default:
+        log.Printf("Broadcast queue full, dropping %s message for room %s", message.Type, message.RoomID)
     }
 }
 
@@ -177,4 +383,75 @@ func (h *Hub) LeaveRoom(client *Client, roomID uuid.UUID) {
     h.mu.Lock()
     defer h.mu.Unlock()
     h.leaveRoom(client, roomID)
-}
\ No newline at end of file
+}
+
+// IsSubscribed reports whether the client has joined the given room
+func (h *Hub) IsSubscribed(client *Client, roomID uuid.UUID) bool {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+    return client.Rooms[roomID]
+}
+
+// SendTo queues a payload for a single client, dropping it if the client
+// is gone or its send buffer is full
+func (h *Hub) SendTo(client *Client, v interface{}) {
+    payload, err := json.Marshal(v)
+    if err != nil {
+        log.Printf("error marshaling payload: %v", err)
+        return
+    }
+
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+
+    if h.Clients[client.UserID][client.ID] != client {
+        return
+    }
+
+    select {
+    case client.Send <- payload:
+    default:
+        log.Printf("Send buffer full for client %s, dropping payload", client.Username)
+    }
+}
+
+// SendToWait queues a payload for a single client like SendTo, but waits
+// up to timeout for room in its send buffer instead of dropping the payload.
+// It returns false if the client is gone or its buffer stayed full.
+func (h *Hub) SendToWait(client *Client, v interface{}, timeout time.Duration) bool {
+    payload, err := json.Marshal(v)
+    if err != nil {
+        log.Printf("error marshaling payload: %v", err)
+        return false
+    }
+
+    // The lock is released between attempts; holding it while waiting
+    // would stall the whole hub behind one slow connection
+    deadline := time.Now().Add(timeout)
+    for {
+        if sent, gone := h.trySend(client, payload); sent || gone {
+            return sent
+        }
+        if time.Now().After(deadline) {
+            return false
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+// trySend queues payload if the client is registered and has room for it
+func (h *Hub) trySend(client *Client, payload []byte) (sent, gone bool) {
+    h.mu.RLock()
+    defer h.mu.RUnlock()
+
+    if h.Clients[client.UserID][client.ID] != client {
+        return false, true
+    }
+
+    select {
+    case client.Send <- payload:
+        return true, false
+    default:
+        return false, false
+    }
+}
@@ -0,0 +1,264 @@
+package websocket
+
+import (
+    "encoding/json"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// newTestHubs starts n hubs sharing one MemoryBroker, as replicas would
+// share Redis
+func newTestHubs(t *testing.T, n int) []*Hub {
+    t.Helper()
+
+    broker := NewMemoryBroker()
+    t.Cleanup(func() { broker.Close() })
+
+    hubs := make([]*Hub, n)
+    for i := range hubs {
+        hubs[i] = NewHub(broker)
+        go hubs[i].Run()
+    }
+    return hubs
+}
+
+// newTestClient registers a connection without a socket; tests read what
+// the hub would write to it from Send
+func newTestClient(hub *Hub, userID, sessionID uuid.UUID) *Client {
+    client := NewClient(hub, nil, userID, sessionID, "user-"+userID.String()[:8])
+    // Register is unbuffered and Run handles one thing at a time, so the
+    // client is registered before the hub sees any later event
+    hub.Register <- client
+    return client
+}
+
+// receive waits for the next payload of the given type sent to the client,
+// skipping others such as join notices
+func receive(t *testing.T, client *Client, typ string) map[string]interface{} {
+    t.Helper()
+
+    timeout := time.After(2 * time.Second)
+    for {
+        select {
+        case payload, ok := <-client.Send:
+            if !ok {
+                t.Fatalf("connection closed waiting for %q", typ)
+            }
+            var msg map[string]interface{}
+            if err := json.Unmarshal(payload, &msg); err != nil {
+                t.Fatalf("invalid payload %s: %v", payload, err)
+            }
+            if msg["type"] == typ {
+                return msg
+            }
+        case <-timeout:
+            t.Fatalf("timed out waiting for %q", typ)
+        }
+    }
+}
+
+func TestHubRoomEventsReachOtherReplicas(t *testing.T) {
+    hubs := newTestHubs(t, 2)
+    roomID := uuid.New()
+
+    sender := newTestClient(hubs[0], uuid.New(), uuid.New())
+    receiver := newTestClient(hubs[1], uuid.New(), uuid.New())
+    outsider := newTestClient(hubs[1], uuid.New(), uuid.New())
+    hubs[0].JoinRoom(sender, roomID)
+    hubs[1].JoinRoom(receiver, roomID)
+
+    hubs[0].Broadcast <- &Message{Type: "message", RoomID: roomID, SenderID: sender.UserID, Content: "hello"}
+
+    for _, client := range []*Client{sender, receiver} {
+        if msg := receive(t, client, "message"); msg["content"] != "hello" {
+            t.Errorf("got content %v, want hello", msg["content"])
+        }
+    }
+
+    hubs[1].BroadcastToRoom(roomID, ReadEvent{Type: "read", RoomID: roomID, UserID: receiver.UserID})
+    receive(t, sender, "read")
+
+    select {
+    case payload := <-outsider.Send:
+        t.Errorf("client outside the room got %s", payload)
+    default:
+    }
+}
+
+func TestHubTypingSkipsTypist(t *testing.T) {
+    hubs := newTestHubs(t, 2)
+    roomID := uuid.New()
+
+    typist := newTestClient(hubs[0], uuid.New(), uuid.New())
+    other := newTestClient(hubs[1], uuid.New(), uuid.New())
+    hubs[0].JoinRoom(typist, roomID)
+    hubs[1].JoinRoom(other, roomID)
+
+    hubs[0].Typing <- &TypingIndicator{RoomID: roomID, UserID: typist.UserID, IsTyping: true}
+    receive(t, other, "typing")
+
+    // A later room event shows whether the typing indicator came first
+    hubs[0].BroadcastToRoom(roomID, ReadEvent{Type: "read", RoomID: roomID})
+    for {
+        select {
+        case payload := <-typist.Send:
+            var msg map[string]interface{}
+            if err := json.Unmarshal(payload, &msg); err != nil {
+                t.Fatalf("invalid payload %s: %v", payload, err)
+            }
+            switch msg["type"] {
+            case "typing":
+                t.Fatal("typist received their own typing indicator")
+            case "read":
+                return
+            }
+        case <-time.After(2 * time.Second):
+            t.Fatal("timed out waiting for read event")
+        }
+    }
+}
+
+func TestHubUserEventsReachOtherReplicas(t *testing.T) {
+    hubs := newTestHubs(t, 2)
+    userID := uuid.New()
+
+    // The same user on both replicas, plus someone else
+    first := newTestClient(hubs[0], userID, uuid.New())
+    second := newTestClient(hubs[1], userID, uuid.New())
+    other := newTestClient(hubs[1], uuid.New(), uuid.New())
+
+    hubs[0].SendToUsers([]uuid.UUID{userID}, InvitationEvent{Type: "invitation"})
+
+    receive(t, first, "invitation")
+    receive(t, second, "invitation")
+
+    select {
+    case payload := <-other.Send:
+        t.Errorf("other user got %s", payload)
+    case <-time.After(50 * time.Millisecond):
+    }
+}
+
+func TestHubCloseSessionOnOtherReplica(t *testing.T) {
+    hubs := newTestHubs(t, 2)
+    userID := uuid.New()
+    revoked := uuid.New()
+
+    target := newTestClient(hubs[1], userID, revoked)
+    kept := newTestClient(hubs[1], userID, uuid.New())
+
+    hubs[0].CloseSession(userID, revoked)
+
+    if msg := receive(t, target, "session_revoked"); msg["session_id"] != revoked.String() {
+        t.Errorf("got session_id %v, want %s", msg["session_id"], revoked)
+    }
+    select {
+    case _, ok := <-target.Send:
+        if ok {
+            t.Error("revoked connection got another payload")
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("revoked connection was not closed")
+    }
+
+    if !hubs[1].IsOnline(userID) {
+        t.Error("user went offline although another session is connected")
+    }
+    select {
+    case payload, ok := <-kept.Send:
+        t.Errorf("other session got %s (open %v)", payload, ok)
+    default:
+    }
+}
+
+func TestHubRemoveFromRoomOnOtherReplica(t *testing.T) {
+    hubs := newTestHubs(t, 2)
+    roomID := uuid.New()
+
+    removed := newTestClient(hubs[1], uuid.New(), uuid.New())
+    staying := newTestClient(hubs[1], uuid.New(), uuid.New())
+    hubs[1].JoinRoom(removed, roomID)
+    hubs[1].JoinRoom(staying, roomID)
+
+    hubs[0].RemoveFromRoom(roomID, removed.UserID)
+
+    if msg := receive(t, removed, "room_removed"); msg["room_id"] != roomID.String() {
+        t.Errorf("got room_id %v, want %s", msg["room_id"], roomID)
+    }
+    if hubs[1].IsSubscribed(removed, roomID) {
+        t.Error("removed user is still subscribed")
+    }
+    if !hubs[1].IsSubscribed(staying, roomID) {
+        t.Error("other member was unsubscribed")
+    }
+
+    // Without users, as when the room is deleted, everyone goes
+    hubs[0].RemoveFromRoom(roomID)
+    receive(t, staying, "room_removed")
+    if hubs[1].IsSubscribed(staying, roomID) {
+        t.Error("member is still subscribed to a deleted room")
+    }
+}
+
+func TestHubSendToWait(t *testing.T) {
+    hub := newTestHubs(t, 1)[0]
+    client := NewClient(hub, nil, uuid.New(), uuid.New(), "replayed")
+
+    if hub.SendToWait(client, ReadEvent{Type: "read"}, time.Second) {
+        t.Error("queued a payload for an unregistered client")
+    }
+
+    // Registered synchronously, so the payload is queued straight away
+    hub.RegisterClient(client)
+    for len(client.Send) < cap(client.Send) {
+        client.Send <- []byte("{}")
+    }
+
+    if hub.SendToWait(client, ReadEvent{Type: "read"}, 20*time.Millisecond) {
+        t.Error("queued a payload into a full buffer")
+    }
+
+    // Once the writer catches up, the payload goes through
+    go func() {
+        time.Sleep(50 * time.Millisecond)
+        <-client.Send
+    }()
+    if !hub.SendToWait(client, ReadEvent{Type: "read"}, 2*time.Second) {
+        t.Error("payload dropped although the buffer drained")
+    }
+}
+
+func TestMemoryBrokerDoesNotDrop(t *testing.T) {
+    broker := NewMemoryBroker()
+    defer broker.Close()
+
+    sub, err := broker.Subscribe()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Far more than any channel buffer, with nobody reading yet
+    const n = 10000
+    for i := 0; i < n; i++ {
+        if err := broker.Publish([]byte{byte(i)}); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    for i := 0; i < n; i++ {
+        payload := <-sub
+        if payload[0] != byte(i) {
+            t.Fatalf("event %d out of order", i)
+        }
+    }
+
+    broker.Close()
+    if _, ok := <-sub; ok {
+        t.Error("subscription still open after Close")
+    }
+    if err := broker.Publish(nil); err != ErrBrokerClosed {
+        t.Errorf("got %v publishing after Close, want ErrBrokerClosed", err)
+    }
+}
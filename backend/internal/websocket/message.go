@@ -2,7 +2,9 @@ package websocket
 
 import (
     "time"
+
     "github.com/google/uuid"
+    "github.com/halizadz/chat-app-backend/internal/models"
 )
 
 // Constants for WebSocket configuration
@@ -11,19 +13,32 @@ const (
     PongWait       = 60 * time.Second
     PingPeriod     = (PongWait * 9) / 10
     MaxMessageSize = 512 * 1024 // 512 KB
+
+    // Users with no inbound frames for this long are shown as away
+    PresenceIdleAfter = 5 * time.Minute
 )
 
 // Message represents a chat message
 type Message struct {
-    Type      string    `json:"type"` // message, typing, join, leave, file
-    RoomID    uuid.UUID `json:"room_id"`
-    SenderID  uuid.UUID `json:"sender_id"`
-    Username  string    `json:"username"`
-    Content   string    `json:"content"`
-    FileURL   string    `json:"file_url,omitempty"`
-    FileName  string    `json:"file_name,omitempty"`
-    FileSize  int64     `json:"file_size,omitempty"`
-    Timestamp time.Time `json:"timestamp"`
+    ID           uuid.UUID              `json:"id,omitzero"`
+    Type         string                 `json:"type"` // message, typing, join, leave, file, image, subscribe, unsubscribe
+    RoomID       uuid.UUID              `json:"room_id"`
+    SenderID     uuid.UUID              `json:"sender_id"`
+    Username     string                 `json:"username"`
+    Content      string                 `json:"content"`
+    FileURL      string                 `json:"file_url,omitempty"`
+    FileName     string                 `json:"file_name,omitempty"`
+    FileSize     int64                  `json:"file_size,omitempty"`
+    Width        int                    `json:"width,omitempty"` // Set for images
+    Height       int                    `json:"height,omitempty"`
+    Thumbnails   models.Thumbnails      `json:"thumbnails,omitempty"`
+    ReplyToID    *uuid.UUID             `json:"reply_to_id,omitempty"`
+    ThreadRootID *uuid.UUID             `json:"thread_root_id,omitempty"`
+    ReplyTo      *models.MessagePreview `json:"reply_to,omitempty"`
+    Seq          int64                  `json:"seq,omitempty"`      // Position in the room, set by the server
+    LastSeq      int64                  `json:"last_seq,omitempty"` // On subscribe: last seq the client has seen
+    Replay       bool                   `json:"replay,omitempty"`   // Sent while catching up after a reconnect
+    Timestamp    time.Time              `json:"timestamp"`
 }
 
 // TypingIndicator represents typing status
@@ -32,4 +47,88 @@ type TypingIndicator struct {
     UserID   uuid.UUID `json:"user_id"`
     Username string    `json:"username"`
     IsTyping bool      `json:"is_typing"`
-}
\ No newline at end of file
+}
+
+// MessageEvent tells a room that a stored message was edited or deleted
+type MessageEvent struct {
+    Type        string              `json:"type"` // message_edited, message_deleted
+    RoomID      uuid.UUID           `json:"room_id"`
+    MessageID   uuid.UUID           `json:"message_id"`
+    Content     string              `json:"content"`
+    LinkPreview *models.LinkPreview `json:"link_preview"` // null once the content changed
+    UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// MessageUpdatedEvent tells a room that the server added to a stored
+// message, such as a preview of the link it contains
+type MessageUpdatedEvent struct {
+    Type        string              `json:"type"` // always "message_updated"
+    RoomID      uuid.UUID           `json:"room_id"`
+    MessageID   uuid.UUID           `json:"message_id"`
+    LinkPreview *models.LinkPreview `json:"link_preview,omitempty"`
+}
+
+// ReactionEvent tells a room that someone added or removed a reaction
+type ReactionEvent struct {
+    Type      string    `json:"type"` // always "reaction"
+    RoomID    uuid.UUID `json:"room_id"`
+    MessageID uuid.UUID `json:"message_id"`
+    UserID    uuid.UUID `json:"user_id"`
+    Username  string    `json:"username"`
+    Emoji     string    `json:"emoji"`
+    Action    string    `json:"action"` // added, removed
+    Count     int       `json:"count"`  // Users now reacting with this emoji
+}
+
+// ReadEvent tells a room how far a user has read
+type ReadEvent struct {
+    Type      string    `json:"type"` // always "read"
+    RoomID    uuid.UUID `json:"room_id"`
+    UserID    uuid.UUID `json:"user_id"`
+    Username  string    `json:"username"`
+    MessageID uuid.UUID `json:"message_id"` // Newest message the user has read
+    Seq       int64     `json:"seq"`
+    ReadAt    time.Time `json:"read_at"`
+}
+
+// RoleEvent tells a room that a member's role changed
+type RoleEvent struct {
+    Type   string    `json:"type"` // always "member_role"
+    RoomID uuid.UUID `json:"room_id"`
+    UserID uuid.UUID `json:"user_id"`
+    Role   string    `json:"role"`
+}
+
+// InvitationEvent tells a user they were invited to a room
+type InvitationEvent struct {
+    Type       string                 `json:"type"` // always "invitation"
+    Invitation *models.RoomInvitation `json:"invitation"`
+}
+
+// Resync tells a client it missed too much to replay and should reload
+// the room's history over REST
+type Resync struct {
+    Type   string    `json:"type"` // always "resync"
+    RoomID uuid.UUID `json:"room_id"`
+}
+
+// SessionRevoked is sent to a connection right before the server closes it
+// because its session was terminated
+type SessionRevoked struct {
+    Type      string    `json:"type"` // always "session_revoked"
+    SessionID uuid.UUID `json:"session_id"`
+}
+
+// RoomRemoved tells a user's connections they no longer belong to a room,
+// because they left, were removed or the room was deleted
+type RoomRemoved struct {
+    Type   string    `json:"type"` // always "room_removed"
+    RoomID uuid.UUID `json:"room_id"`
+}
+
+// Error is sent back to a single connection when one of its frames is rejected
+type Error struct {
+    Type    string    `json:"type"`             // always "error"
+    RoomID  uuid.UUID `json:"room_id,omitzero"` // Unset for errors about the connection itself
+    Content string    `json:"content"`
+}
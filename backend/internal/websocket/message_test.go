@@ -0,0 +1,28 @@
+package websocket
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+
+    "github.com/google/uuid"
+)
+
+func TestErrorOmitsMissingRoom(t *testing.T) {
+    data, err := json.Marshal(Error{Type: "error", Content: "room_id is required"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Contains(string(data), "room_id\":") {
+        t.Errorf("connection error has a room_id: %s", data)
+    }
+
+    roomID := uuid.New()
+    data, err = json.Marshal(Error{Type: "error", RoomID: roomID, Content: "Not subscribed to this room"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(data), roomID.String()) {
+        t.Errorf("room error lacks its room_id: %s", data)
+    }
+}
@@ -0,0 +1,224 @@
+package websocket
+
+import (
+    "log"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// Presence statuses stored in users.status
+const (
+    StatusOnline  = "online"
+    StatusAway    = "away"
+    StatusOffline = "offline"
+)
+
+// StatusUpdater persists a user's presence status and last_seen time
+type StatusUpdater interface {
+    UpdateStatus(userID uuid.UUID, status string) error
+}
+
+// PeerFinder lists the users who share at least one room with a user
+type PeerFinder interface {
+    RoomPeerIDs(userID uuid.UUID) ([]uuid.UUID, error)
+}
+
+// PresenceEvent tells a user's room peers that their status changed
+type PresenceEvent struct {
+    Type     string    `json:"type"` // always "presence"
+    UserID   uuid.UUID `json:"user_id"`
+    Username string    `json:"username"`
+    Status   string    `json:"status"` // online, away, offline
+    LastSeen time.Time `json:"last_seen"`
+}
+
+// Presence derives user status from hub connections: online on the first
+// connection, away after idleAfter without inbound frames, and offline
+// when the last connection closes. Connections and activity are counted
+// across replicas through store, so a user connected to two replicas stays
+// online until both have lost them, and only goes away once idle on both.
+type Presence struct {
+    hub       *Hub
+    users     StatusUpdater
+    peers     PeerFinder
+    store     PresenceStore
+    idleAfter time.Duration
+
+    mu         sync.Mutex
+    status     map[uuid.UUID]string
+    usernames  map[uuid.UUID]string
+    lastActive map[uuid.UUID]time.Time
+
+    // changes are persisted and announced in order by Run
+    changes chan presenceChange
+}
+
+// presenceChange is a status change waiting for Run. connecting marks the
+// user's first connection to this replica.
+type presenceChange struct {
+    PresenceEvent
+    connecting bool
+}
+
+func NewPresence(hub *Hub, users StatusUpdater, peers PeerFinder, store PresenceStore, idleAfter time.Duration) *Presence {
+    p := &Presence{
+        hub:        hub,
+        users:      users,
+        peers:      peers,
+        store:      store,
+        idleAfter:  idleAfter,
+        status:     make(map[uuid.UUID]string),
+        usernames:  make(map[uuid.UUID]string),
+        lastActive: make(map[uuid.UUID]time.Time),
+        changes:    make(chan presenceChange, 256),
+    }
+    hub.presence = p
+    return p
+}
+
+// Run persists status changes, announces them to room peers, marks idle
+// users away and keeps this replica's claims in the store alive
+func (p *Presence) Run() {
+    ticker := time.NewTicker(p.idleAfter / 4)
+    defer ticker.Stop()
+
+    heartbeat := time.NewTicker(presenceHeartbeat)
+    defer heartbeat.Stop()
+
+    for {
+        select {
+        case change := <-p.changes:
+            p.announce(change)
+
+        case <-heartbeat.C:
+            p.mu.Lock()
+            users := make(map[uuid.UUID]bool, len(p.status))
+            for userID, status := range p.status {
+                users[userID] = status == StatusOnline
+            }
+            p.mu.Unlock()
+
+            if err := p.store.Refresh(users); err != nil {
+                log.Printf("error refreshing presence: %v", err)
+            }
+
+        case now := <-ticker.C:
+            p.mu.Lock()
+            for userID, status := range p.status {
+                if status == StatusOnline && now.Sub(p.lastActive[userID]) >= p.idleAfter {
+                    p.setLocked(userID, StatusAway)
+                }
+            }
+            p.mu.Unlock()
+        }
+    }
+}
+
+func (p *Presence) announce(change presenceChange) {
+    switch {
+    case change.connecting:
+        if _, err := p.store.Connect(change.UserID); err != nil {
+            log.Printf("error recording connection for user %s: %v", change.UserID, err)
+        }
+
+    case change.Status == StatusOffline:
+        // If the store can't be reached, go by this replica alone
+        remaining, err := p.store.Disconnect(change.UserID)
+        if err != nil {
+            log.Printf("error recording disconnection for user %s: %v", change.UserID, err)
+        } else if remaining > 0 {
+            // Still connected through another replica
+            return
+        }
+
+    case change.Status == StatusAway:
+        active, err := p.store.SetActive(change.UserID, false)
+        if err != nil {
+            log.Printf("error recording idleness for user %s: %v", change.UserID, err)
+        } else if active > 0 {
+            // Still active through another replica
+            return
+        }
+
+    case change.Status == StatusOnline:
+        if _, err := p.store.SetActive(change.UserID, true); err != nil {
+            log.Printf("error recording activity for user %s: %v", change.UserID, err)
+        }
+    }
+
+    if err := p.users.UpdateStatus(change.UserID, change.Status); err != nil {
+        log.Printf("error updating status for user %s: %v", change.UserID, err)
+    }
+
+    peers, err := p.peers.RoomPeerIDs(change.UserID)
+    if err != nil {
+        log.Printf("error finding room peers for user %s: %v", change.UserID, err)
+        return
+    }
+
+    // The user's own devices hear about it too
+    p.hub.SendToUsers(append(peers, change.UserID), change.PresenceEvent)
+}
+
+// connected is called by the hub when a user opens their first connection
+func (p *Presence) connected(userID uuid.UUID, username string) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.usernames[userID] = username
+    p.lastActive[userID] = time.Now()
+    p.setLocked(userID, StatusOnline)
+}
+
+// disconnected is called by the hub when a user's last connection closes
+func (p *Presence) disconnected(userID uuid.UUID) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    p.setLocked(userID, StatusOffline)
+    delete(p.status, userID)
+    delete(p.usernames, userID)
+    delete(p.lastActive, userID)
+}
+
+// touch records activity, bringing an away user back online
+func (p *Presence) touch(userID uuid.UUID) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    if _, ok := p.status[userID]; !ok {
+        return
+    }
+
+    p.lastActive[userID] = time.Now()
+    p.setLocked(userID, StatusOnline)
+}
+
+// setLocked queues a change if the status differs. Callers must hold p.mu.
+func (p *Presence) setLocked(userID uuid.UUID, status string) {
+    previous := p.status[userID]
+    if previous == status {
+        return
+    }
+    p.status[userID] = status
+
+    change := presenceChange{
+        PresenceEvent: PresenceEvent{
+            Type:     "presence",
+            UserID:   userID,
+            Username: p.usernames[userID],
+            Status:   status,
+            LastSeen: time.Now(),
+        },
+        // Only connected adds users without a status
+        connecting: previous == "",
+    }
+
+    select {
+    case p.changes <- change:
+    default:
+        log.Printf("Presence queue full, dropping %s update for user %s", status, userID)
+    }
+}
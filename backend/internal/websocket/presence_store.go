@@ -0,0 +1,201 @@
+package websocket
+
+import (
+    "fmt"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    // How often a replica renews its claim on the users connected to it,
+    // and how long an unrenewed claim lasts
+    presenceHeartbeat = 30 * time.Second
+    presenceClaimTTL  = 3 * presenceHeartbeat
+)
+
+// PresenceStore tracks which replicas each user is connected to, and on
+// which of them they are active, so a user goes offline only once the last
+// replica loses their connections and away only once idle on all of them
+type PresenceStore interface {
+    // Connect claims the user for this replica, as connected and active,
+    // and returns how many replicas hold a connection claim
+    Connect(userID uuid.UUID) (int, error)
+
+    // Disconnect drops this replica's claims and returns how many
+    // connection claims remain
+    Disconnect(userID uuid.UUID) (int, error)
+
+    // SetActive records whether the user is active on this replica and
+    // returns how many replicas have them active
+    SetActive(userID uuid.UUID, active bool) (int, error)
+
+    // Refresh renews this replica's claims on users still connected to it;
+    // the value says whether they are active. Claims not renewed lapse, so
+    // a replica that dies doesn't keep its users online elsewhere.
+    Refresh(users map[uuid.UUID]bool) error
+}
+
+// MemoryPresenceStore is a PresenceStore for single-node deployments, where
+// this replica's claims are the only ones
+type MemoryPresenceStore struct {
+    mu    sync.Mutex
+    users map[uuid.UUID]bool // Connected users, and whether they are active
+}
+
+func NewMemoryPresenceStore() *MemoryPresenceStore {
+    return &MemoryPresenceStore{users: make(map[uuid.UUID]bool)}
+}
+
+func (s *MemoryPresenceStore) Connect(userID uuid.UUID) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.users[userID] = true
+    return 1, nil
+}
+
+func (s *MemoryPresenceStore) Disconnect(userID uuid.UUID) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.users, userID)
+    return 0, nil
+}
+
+func (s *MemoryPresenceStore) SetActive(userID uuid.UUID, active bool) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if _, ok := s.users[userID]; !ok {
+        return 0, nil
+    }
+    s.users[userID] = active
+    if active {
+        return 1, nil
+    }
+    return 0, nil
+}
+
+func (s *MemoryPresenceStore) Refresh(users map[uuid.UUID]bool) error {
+    return nil
+}
+
+// Each user has two sorted sets of replicas holding a claim on them, one
+// for connections and one for activity, scored by when the claim lapses.
+// Lapsed claims are pruned before counting. Keys expire with their newest
+// claim, so departed users leave nothing behind.
+const (
+    // KEYS the sets to claim; ARGV replica ID, lapse time, now, TTL (ms).
+    // Returns the claims in the first set.
+    presenceClaimScript = `
+for _, key in ipairs(KEYS) do
+    redis.call('ZADD', key, ARGV[2], ARGV[1])
+    redis.call('ZREMRANGEBYSCORE', key, '-inf', ARGV[3])
+    redis.call('PEXPIRE', key, ARGV[4])
+end
+return redis.call('ZCARD', KEYS[1])`
+
+    // KEYS the sets to release; ARGV replica ID, now (ms). Returns the
+    // claims left in the first set.
+    presenceReleaseScript = `
+for _, key in ipairs(KEYS) do
+    redis.call('ZREM', key, ARGV[1])
+    redis.call('ZREMRANGEBYSCORE', key, '-inf', ARGV[2])
+end
+return redis.call('ZCARD', KEYS[1])`
+)
+
+// RedisPresenceStore is a PresenceStore shared by every replica through Redis
+type RedisPresenceStore struct {
+    client    *redisClient
+    replicaID string
+}
+
+// NewRedisPresenceStore creates a store for this replica. redisURL takes
+// the same forms as for NewRedisBroker.
+func NewRedisPresenceStore(redisURL string) (*RedisPresenceStore, error) {
+    client, err := newRedisClient(redisURL)
+    if err != nil {
+        return nil, err
+    }
+
+    return &RedisPresenceStore{
+        client:    client,
+        replicaID: uuid.New().String(),
+    }, nil
+}
+
+func (s *RedisPresenceStore) Connect(userID uuid.UUID) (int, error) {
+    return s.claim(connectedKey(userID), activeKey(userID))
+}
+
+func (s *RedisPresenceStore) Disconnect(userID uuid.UUID) (int, error) {
+    return s.release(connectedKey(userID), activeKey(userID))
+}
+
+func (s *RedisPresenceStore) SetActive(userID uuid.UUID, active bool) (int, error) {
+    if active {
+        return s.claim(activeKey(userID))
+    }
+    return s.release(activeKey(userID))
+}
+
+func (s *RedisPresenceStore) Refresh(users map[uuid.UUID]bool) error {
+    for userID, active := range users {
+        keys := []string{connectedKey(userID)}
+        if active {
+            keys = append(keys, activeKey(userID))
+        }
+        if _, err := s.claim(keys...); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *RedisPresenceStore) Close() error {
+    s.client.close()
+    return nil
+}
+
+func (s *RedisPresenceStore) claim(keys ...string) (int, error) {
+    now := time.Now()
+    return s.eval(presenceClaimScript, keys,
+        s.replicaID,
+        millis(now.Add(presenceClaimTTL)),
+        millis(now),
+        strconv.FormatInt(presenceClaimTTL.Milliseconds(), 10))
+}
+
+func (s *RedisPresenceStore) release(keys ...string) (int, error) {
+    return s.eval(presenceReleaseScript, keys, s.replicaID, millis(time.Now()))
+}
+
+// eval runs a script against keys and returns the count it reports
+func (s *RedisPresenceStore) eval(script string, keys []string, args ...string) (int, error) {
+    cmd := []string{"EVAL", script, strconv.Itoa(len(keys))}
+    cmd = append(cmd, keys...)
+    cmd = append(cmd, args...)
+    reply, err := s.client.do(cmd...)
+    if err != nil {
+        return 0, err
+    }
+
+    count, ok := reply.(int64)
+    if !ok {
+        return 0, fmt.Errorf("unexpected presence reply %v", reply)
+    }
+    return int(count), nil
+}
+
+func connectedKey(userID uuid.UUID) string {
+    return "presence:" + userID.String()
+}
+
+func activeKey(userID uuid.UUID) string {
+    return "presence:" + userID.String() + ":active"
+}
+
+func millis(t time.Time) string {
+    return strconv.FormatInt(t.UnixMilli(), 10)
+}
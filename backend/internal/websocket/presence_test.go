@@ -0,0 +1,254 @@
+package websocket
+
+import (
+    "net"
+    "reflect"
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/google/uuid"
+)
+
+type statusUpdate struct {
+    userID uuid.UUID
+    status string
+}
+
+type recordingUsers chan statusUpdate
+
+func (r recordingUsers) UpdateStatus(userID uuid.UUID, status string) error {
+    r <- statusUpdate{userID, status}
+    return nil
+}
+
+type noPeers struct{}
+
+func (noPeers) RoomPeerIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+    return nil, nil
+}
+
+// sharedStore is a PresenceStore for one user, where a replica called
+// "other" may hold claims besides this one's
+type sharedStore struct {
+    mu        sync.Mutex
+    connected map[string]bool
+    active    map[string]bool
+}
+
+func newSharedStore() *sharedStore {
+    return &sharedStore{connected: make(map[string]bool), active: make(map[string]bool)}
+}
+
+func (s *sharedStore) set(replica string, connected, active bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.connected[replica] = connected
+    s.active[replica] = active
+}
+
+func count(claims map[string]bool) int {
+    n := 0
+    for _, claimed := range claims {
+        if claimed {
+            n++
+        }
+    }
+    return n
+}
+
+func (s *sharedStore) Connect(userID uuid.UUID) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.connected["local"], s.active["local"] = true, true
+    return count(s.connected), nil
+}
+
+func (s *sharedStore) Disconnect(userID uuid.UUID) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.connected["local"], s.active["local"] = false, false
+    return count(s.connected), nil
+}
+
+func (s *sharedStore) SetActive(userID uuid.UUID, active bool) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.active["local"] = active
+    return count(s.active), nil
+}
+
+func (s *sharedStore) Refresh(users map[uuid.UUID]bool) error {
+    return nil
+}
+
+func expectStatus(t *testing.T, updates recordingUsers, status string) {
+    t.Helper()
+
+    select {
+    case update := <-updates:
+        if update.status != status {
+            t.Fatalf("got status %q, want %q", update.status, status)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatalf("timed out waiting for %q", status)
+    }
+}
+
+func TestPresenceStaysOnlineWhileAnotherReplicaHasUser(t *testing.T) {
+    hub := newTestHubs(t, 1)[0]
+    updates := make(recordingUsers, 16)
+    userID := uuid.New()
+
+    // Another replica already has the user connected
+    store := newSharedStore()
+    store.set("other", true, true)
+    presence := NewPresence(hub, updates, noPeers{}, store, time.Hour)
+    go presence.Run()
+
+    client := newTestClient(hub, userID, uuid.New())
+    expectStatus(t, updates, StatusOnline)
+
+    hub.Unregister <- client
+    expectNoStatus(t, updates)
+
+    // The other replica loses the user too, and then this one sees them again
+    store.set("other", false, false)
+    client = newTestClient(hub, userID, uuid.New())
+    expectStatus(t, updates, StatusOnline)
+
+    hub.Unregister <- client
+    expectStatus(t, updates, StatusOffline)
+}
+
+func TestPresenceAwayOnlyWhenIdleEverywhere(t *testing.T) {
+    hub := newTestHubs(t, 1)[0]
+    updates := make(recordingUsers, 16)
+
+    // The user is active on another replica while idle on this one
+    store := newSharedStore()
+    store.set("other", true, true)
+    presence := NewPresence(hub, updates, noPeers{}, store, 40*time.Millisecond)
+    go presence.Run()
+
+    userID := uuid.New()
+    client := newTestClient(hub, userID, uuid.New())
+    expectStatus(t, updates, StatusOnline)
+    expectNoStatus(t, updates)
+
+    // Once idle there as well, the user goes away for real
+    hub.Unregister <- client
+    store.set("other", true, false)
+    newTestClient(hub, userID, uuid.New())
+    expectStatus(t, updates, StatusOnline)
+    expectStatus(t, updates, StatusAway)
+}
+
+// expectNoStatus checks no status is written for a while
+func expectNoStatus(t *testing.T, updates recordingUsers) {
+    t.Helper()
+
+    select {
+    case update := <-updates:
+        t.Fatalf("got status %q", update.status)
+    case <-time.After(200 * time.Millisecond):
+    }
+}
+
+func TestRedisPresenceStore(t *testing.T) {
+    var mu sync.Mutex
+    claims := make(map[string]map[string]bool)
+
+    // Enough of the scripts' effect to count claims per key
+    server := newFakeRedis(t, func(conn net.Conn, cmd []string) {
+        if cmd[0] != "EVAL" {
+            return
+        }
+        numKeys, _ := strconv.Atoi(cmd[2])
+        keys, replica := cmd[3:3+numKeys], cmd[3+numKeys]
+
+        mu.Lock()
+        for _, key := range keys {
+            if claims[key] == nil {
+                claims[key] = make(map[string]bool)
+            }
+            if cmd[1] == presenceClaimScript {
+                claims[key][replica] = true
+            } else {
+                delete(claims[key], replica)
+            }
+        }
+        count := len(claims[keys[0]])
+        mu.Unlock()
+
+        conn.Write([]byte(":" + strconv.Itoa(count) + "\r\n"))
+    })
+
+    first, err := NewRedisPresenceStore(server.listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer first.Close()
+    second, err := NewRedisPresenceStore(server.listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer second.Close()
+
+    idle := func(s *RedisPresenceStore) func(uuid.UUID) (int, error) {
+        return func(userID uuid.UUID) (int, error) { return s.SetActive(userID, false) }
+    }
+    active := func(s *RedisPresenceStore) func(uuid.UUID) (int, error) {
+        return func(userID uuid.UUID) (int, error) { return s.SetActive(userID, true) }
+    }
+
+    userID := uuid.New()
+    steps := []struct {
+        name string
+        do   func(uuid.UUID) (int, error)
+        want int
+    }{
+        {"first connects", first.Connect, 1},
+        {"second connects", second.Connect, 2},
+        {"first reconnects", first.Connect, 2},
+        {"first goes idle", idle(first), 1},
+        {"second goes idle", idle(second), 0},
+        {"first comes back", active(first), 1},
+        {"first disconnects", first.Disconnect, 1},
+        {"second disconnects", second.Disconnect, 0},
+    }
+    for _, step := range steps {
+        count, err := step.do(userID)
+        if err != nil {
+            t.Fatalf("%s: %v", step.name, err)
+        }
+        if count != step.want {
+            t.Errorf("%s: got %d replicas, want %d", step.name, count, step.want)
+        }
+    }
+    for range steps {
+        <-server.commands
+    }
+
+    // Active users renew both claims, idle ones only their connection
+    for _, isActive := range []bool{true, false} {
+        if err := first.Refresh(map[uuid.UUID]bool{userID: isActive}); err != nil {
+            t.Fatal(err)
+        }
+        cmd := <-server.commands
+
+        want := []string{connectedKey(userID)}
+        if isActive {
+            want = append(want, activeKey(userID))
+        }
+        numKeys, _ := strconv.Atoi(cmd[2])
+        keys, args := cmd[3:3+numKeys], cmd[3+numKeys:]
+        if cmd[1] != presenceClaimScript || !reflect.DeepEqual(keys, want) || args[0] != first.replicaID {
+            t.Errorf("refresh sent %q", cmd)
+        }
+        if ttl, _ := strconv.ParseInt(args[3], 10, 64); ttl != presenceClaimTTL.Milliseconds() {
+            t.Errorf("refresh set ttl %q", args[3])
+        }
+    }
+}
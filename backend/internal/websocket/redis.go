@@ -0,0 +1,233 @@
+package websocket
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    redisDialTimeout  = 5 * time.Second
+    redisIOTimeout    = 5 * time.Second
+    redisMaxReconnect = 30 * time.Second
+
+    // How often a subscription is pinged. One that stays silent for two
+    // intervals is taken to be dead and dialed again.
+    redisPingInterval = 30 * time.Second
+)
+
+// redisClient speaks the Redis wire protocol directly. It keeps one
+// connection for commands, shared by all callers, and dials more on
+// request for long-lived uses such as SUBSCRIBE.
+type redisClient struct {
+    addr     string
+    password string
+    db       int
+
+    // conn is guarded by mu
+    mu   sync.Mutex
+    conn net.Conn
+    read *bufio.Reader
+}
+
+// newRedisClient parses redisURL, either host:port or
+// redis://[:password@]host:port[/db], and checks Redis is reachable
+func newRedisClient(redisURL string) (*redisClient, error) {
+    c := &redisClient{}
+
+    if !strings.Contains(redisURL, "://") {
+        c.addr = redisURL
+    } else {
+        u, err := url.Parse(redisURL)
+        if err != nil {
+            return nil, fmt.Errorf("invalid redis url: %w", err)
+        }
+        if u.Scheme != "redis" {
+            return nil, fmt.Errorf("unsupported redis scheme %q", u.Scheme)
+        }
+        c.addr = u.Host
+        if u.User != nil {
+            c.password, _ = u.User.Password()
+        }
+        if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+            if c.db, err = strconv.Atoi(db); err != nil {
+                return nil, fmt.Errorf("invalid redis database %q", db)
+            }
+        }
+    }
+
+    if c.addr == "" {
+        return nil, fmt.Errorf("redis address is required")
+    }
+    if !strings.Contains(c.addr, ":") {
+        c.addr += ":6379"
+    }
+
+    // Fail fast if Redis is unreachable at startup
+    conn, _, err := c.dial()
+    if err != nil {
+        return nil, err
+    }
+    conn.Close()
+
+    return c, nil
+}
+
+// dial opens an authenticated connection with the right database selected
+func (c *redisClient) dial() (net.Conn, *bufio.Reader, error) {
+    conn, err := net.DialTimeout("tcp", c.addr, redisDialTimeout)
+    if err != nil {
+        return nil, nil, fmt.Errorf("error connecting to redis: %w", err)
+    }
+
+    r := bufio.NewReader(conn)
+    conn.SetDeadline(time.Now().Add(redisIOTimeout))
+
+    if c.password != "" {
+        if _, err := redisCommand(conn, r, "AUTH", c.password); err != nil {
+            conn.Close()
+            return nil, nil, fmt.Errorf("error authenticating to redis: %w", err)
+        }
+    }
+    if c.db != 0 {
+        if _, err := redisCommand(conn, r, "SELECT", strconv.Itoa(c.db)); err != nil {
+            conn.Close()
+            return nil, nil, fmt.Errorf("error selecting redis database: %w", err)
+        }
+    }
+
+    conn.SetDeadline(time.Time{})
+    return conn, r, nil
+}
+
+// do runs a command on the shared connection and returns its reply
+func (c *redisClient) do(args ...string) (interface{}, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    // Retry once on a fresh connection if the cached one went stale
+    var err error
+    for attempt := 0; attempt < 2; attempt++ {
+        if c.conn == nil {
+            c.conn, c.read, err = c.dial()
+            if err != nil {
+                return nil, err
+            }
+        }
+
+        c.conn.SetDeadline(time.Now().Add(redisIOTimeout))
+        var reply interface{}
+        reply, err = redisCommand(c.conn, c.read, args...)
+        if err == nil {
+            c.conn.SetDeadline(time.Time{})
+            return reply, nil
+        }
+        if _, ok := err.(redisError); ok {
+            // Redis answered; the connection is fine
+            c.conn.SetDeadline(time.Time{})
+            return nil, err
+        }
+
+        c.conn.Close()
+        c.conn, c.read = nil, nil
+    }
+
+    return nil, err
+}
+
+// close drops the shared connection; later commands dial a new one
+func (c *redisClient) close() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if c.conn != nil {
+        c.conn.Close()
+        c.conn, c.read = nil, nil
+    }
+}
+
+// redisError is an error reply from Redis, as opposed to a failure to
+// reach it
+type redisError string
+
+func (e redisError) Error() string {
+    return "redis: " + string(e)
+}
+
+// redisCommand sends a command and reads a single reply
+func redisCommand(w io.Writer, r *bufio.Reader, args ...string) (interface{}, error) {
+    if err := writeRedisCommand(w, args...); err != nil {
+        return nil, err
+    }
+    return readRedisReply(r)
+}
+
+// writeRedisCommand encodes args as a RESP array of bulk strings
+func writeRedisCommand(w io.Writer, args ...string) error {
+    var sb strings.Builder
+    fmt.Fprintf(&sb, "*%d\r\n", len(args))
+    for _, arg := range args {
+        fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(arg), arg)
+    }
+    _, err := io.WriteString(w, sb.String())
+    return err
+}
+
+// readRedisReply decodes one RESP value. Bulk strings and simple strings
+// become string, integers int64, arrays []interface{} and nil bulk
+// strings nil. Error replies are returned as errors.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+    line, err := r.ReadString('\n')
+    if err != nil {
+        return nil, err
+    }
+    line = strings.TrimSuffix(line, "\r\n")
+    if len(line) == 0 {
+        return nil, fmt.Errorf("empty redis reply")
+    }
+
+    switch line[0] {
+    case '+':
+        return line[1:], nil
+    case '-':
+        return nil, redisError(line[1:])
+    case ':':
+        return strconv.ParseInt(line[1:], 10, 64)
+    case '$':
+        n, err := strconv.Atoi(line[1:])
+        if err != nil {
+            return nil, fmt.Errorf("invalid bulk length %q", line[1:])
+        }
+        if n < 0 {
+            return nil, nil
+        }
+        buf := make([]byte, n+2)
+        if _, err := io.ReadFull(r, buf); err != nil {
+            return nil, err
+        }
+        return string(buf[:n]), nil
+    case '*':
+        n, err := strconv.Atoi(line[1:])
+        if err != nil {
+            return nil, fmt.Errorf("invalid array length %q", line[1:])
+        }
+        if n < 0 {
+            return nil, nil
+        }
+        items := make([]interface{}, n)
+        for i := range items {
+            if items[i], err = readRedisReply(r); err != nil {
+                return nil, err
+            }
+        }
+        return items, nil
+    }
+
+    return nil, fmt.Errorf("unknown redis reply type %q", line[0])
+}
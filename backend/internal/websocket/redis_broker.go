@@ -0,0 +1,178 @@
+package websocket
+
+import (
+    "fmt"
+    "log"
+    "net"
+    "sync"
+    "time"
+)
+
+// RedisBroker is a Broker backed by Redis PUBLISH/SUBSCRIBE. It speaks the
+// Redis wire protocol directly and reconnects on its own when the
+// subscription connection drops.
+type RedisBroker struct {
+    client       *redisClient
+    channel      string
+    pingInterval time.Duration
+
+    subMu   sync.Mutex
+    subConn net.Conn
+
+    closed chan struct{}
+    once   sync.Once
+}
+
+// NewRedisBroker creates a broker publishing on channel. redisURL is either
+// host:port or redis://[:password@]host:port[/db].
+func NewRedisBroker(redisURL, channel string) (*RedisBroker, error) {
+    client, err := newRedisClient(redisURL)
+    if err != nil {
+        return nil, err
+    }
+
+    return &RedisBroker{
+        client:       client,
+        channel:      channel,
+        pingInterval: redisPingInterval,
+        closed:       make(chan struct{}),
+    }, nil
+}
+
+func (b *RedisBroker) Publish(payload []byte) error {
+    select {
+    case <-b.closed:
+        return ErrBrokerClosed
+    default:
+    }
+
+    if _, err := b.client.do("PUBLISH", b.channel, string(payload)); err != nil {
+        return fmt.Errorf("error publishing to redis: %w", err)
+    }
+    return nil
+}
+
+func (b *RedisBroker) Subscribe() (<-chan []byte, error) {
+    select {
+    case <-b.closed:
+        return nil, ErrBrokerClosed
+    default:
+    }
+
+    out := make(chan []byte, 256)
+    go b.subscribeLoop(out)
+    return out, nil
+}
+
+// subscribeLoop keeps a SUBSCRIBE connection open until the broker is closed
+func (b *RedisBroker) subscribeLoop(out chan<- []byte) {
+    defer close(out)
+
+    backoff := time.Second
+    for {
+        err := b.subscribeOnce(out)
+
+        select {
+        case <-b.closed:
+            return
+        default:
+        }
+
+        log.Printf("Redis subscription lost: %v (retrying in %s)", err, backoff)
+        select {
+        case <-b.closed:
+            return
+        case <-time.After(backoff):
+        }
+
+        backoff *= 2
+        if backoff > redisMaxReconnect {
+            backoff = redisMaxReconnect
+        }
+    }
+}
+
+func (b *RedisBroker) subscribeOnce(out chan<- []byte) error {
+    conn, r, err := b.client.dial()
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    b.subMu.Lock()
+    b.subConn = conn
+    b.subMu.Unlock()
+
+    if err := writeRedisCommand(conn, "SUBSCRIBE", b.channel); err != nil {
+        return err
+    }
+
+    // A half-open connection never errors on its own. Pings make sure
+    // something arrives regularly, so the read deadline catches it.
+    done := make(chan struct{})
+    defer close(done)
+    go b.ping(conn, done)
+
+    for {
+        conn.SetReadDeadline(time.Now().Add(2 * b.pingInterval))
+        reply, err := readRedisReply(r)
+        if err != nil {
+            return err
+        }
+
+        parts, ok := reply.([]interface{})
+        if !ok || len(parts) != 3 {
+            continue
+        }
+        kind, _ := parts[0].(string)
+        if kind != "message" {
+            continue
+        }
+        payload, ok := parts[2].(string)
+        if !ok {
+            continue
+        }
+
+        // Block rather than drop; Redis buffers behind us in the meantime
+        select {
+        case out <- []byte(payload):
+        case <-b.closed:
+            return nil
+        }
+    }
+}
+
+// ping sends PING on a subscription connection until done is closed. A
+// failed write closes the connection, which ends the read loop.
+func (b *RedisBroker) ping(conn net.Conn, done <-chan struct{}) {
+    ticker := time.NewTicker(b.pingInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-done:
+            return
+        case <-ticker.C:
+            conn.SetWriteDeadline(time.Now().Add(redisIOTimeout))
+            if err := writeRedisCommand(conn, "PING"); err != nil {
+                conn.Close()
+                return
+            }
+        }
+    }
+}
+
+func (b *RedisBroker) Close() error {
+    b.once.Do(func() {
+        close(b.closed)
+
+        b.client.close()
+
+        b.subMu.Lock()
+        if b.subConn != nil {
+            b.subConn.Close()
+        }
+        b.subMu.Unlock()
+    })
+    return nil
+}
@@ -0,0 +1,134 @@
+package websocket
+
+import (
+    "net"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestRedisBrokerPublishAndSubscribe(t *testing.T) {
+    server := newFakeRedis(t, func(conn net.Conn, cmd []string) {
+        switch cmd[0] {
+        case "AUTH", "SELECT":
+            conn.Write([]byte("+OK\r\n"))
+        case "PUBLISH":
+            conn.Write([]byte(":1\r\n"))
+        case "SUBSCRIBE":
+            // The confirmation, then two messages and one for another channel
+            conn.Write([]byte("*3\r\n$9\r\nsubscribe\r\n$4\r\nchat\r\n:1\r\n" +
+                "*3\r\n$7\r\nmessage\r\n$4\r\nchat\r\n$5\r\nfirst\r\n" +
+                "*3\r\n$7\r\nmessage\r\n$4\r\nchat\r\n$6\r\nsecond\r\n"))
+        }
+    })
+
+    broker, err := NewRedisBroker("redis://:secret@"+server.listener.Addr().String()+"/2", "chat")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer broker.Close()
+
+    // The startup connection check
+    server.expect(t, "AUTH", "secret")
+    server.expect(t, "SELECT", "2")
+
+    if err := broker.Publish([]byte(`{"room_id":1}`)); err != nil {
+        t.Fatalf("publish: %v", err)
+    }
+    server.expect(t, "AUTH", "secret")
+    server.expect(t, "SELECT", "2")
+    server.expect(t, "PUBLISH", "chat", `{"room_id":1}`)
+
+    events, err := broker.Subscribe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, want := range []string{"first", "second"} {
+        select {
+        case got := <-events:
+            if string(got) != want {
+                t.Errorf("got event %q, want %q", got, want)
+            }
+        case <-time.After(2 * time.Second):
+            t.Fatalf("timed out waiting for %q", want)
+        }
+    }
+
+    broker.Close()
+    select {
+    case _, ok := <-events:
+        if ok {
+            t.Error("got an event after Close")
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("subscription not closed after Close")
+    }
+    if err := broker.Publish(nil); err != ErrBrokerClosed {
+        t.Errorf("got %v publishing after Close, want ErrBrokerClosed", err)
+    }
+}
+
+func TestRedisBrokerResubscribesWhenRedisGoesSilent(t *testing.T) {
+    // Answers the first SUBSCRIBE, then nothing, like a half-open connection
+    var subscribes int32
+    server := newFakeRedis(t, func(conn net.Conn, cmd []string) {
+        if cmd[0] == "SUBSCRIBE" && atomic.AddInt32(&subscribes, 1) == 1 {
+            conn.Write([]byte("*3\r\n$9\r\nsubscribe\r\n$4\r\nchat\r\n:1\r\n"))
+        }
+    })
+
+    broker, err := NewRedisBroker(server.listener.Addr().String(), "chat")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer broker.Close()
+    broker.pingInterval = 50 * time.Millisecond
+
+    if _, err := broker.Subscribe(); err != nil {
+        t.Fatal(err)
+    }
+
+    server.expect(t, "SUBSCRIBE", "chat")
+    server.expect(t, "PING")
+    // Pings go unanswered, so the broker gives up on the connection and,
+    // after its backoff, subscribes again
+    deadline := time.After(3 * time.Second)
+    for {
+        select {
+        case cmd := <-server.commands:
+            if cmd[0] == "SUBSCRIBE" {
+                return
+            }
+        case <-deadline:
+            t.Fatal("broker did not resubscribe")
+        }
+    }
+}
+
+func TestRedisBrokerPublishError(t *testing.T) {
+    server := newFakeRedis(t, func(conn net.Conn, cmd []string) {
+        if cmd[0] == "PUBLISH" {
+            conn.Write([]byte("-ERR no publishing today\r\n"))
+        }
+    })
+
+    broker, err := NewRedisBroker(server.listener.Addr().String(), "chat")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer broker.Close()
+
+    err = broker.Publish([]byte("payload"))
+    if err == nil || !strings.Contains(err.Error(), "no publishing today") {
+        t.Errorf("got %v, want the server's error", err)
+    }
+}
+
+func TestNewRedisBrokerRejectsBadURLs(t *testing.T) {
+    for _, redisURL := range []string{"", "http://localhost:6379", "redis://localhost:6379/abc"} {
+        if _, err := NewRedisBroker(redisURL, "chat"); err == nil {
+            t.Errorf("no error for %q", redisURL)
+        }
+    }
+}
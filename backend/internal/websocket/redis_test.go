@@ -0,0 +1,142 @@
+package websocket
+
+import (
+    "bufio"
+    "net"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestReadRedisReply(t *testing.T) {
+    tests := []struct {
+        name  string
+        frame string
+        want  interface{}
+    }{
+        {"simple string", "+OK\r\n", "OK"},
+        {"integer", ":42\r\n", int64(42)},
+        {"bulk string", "$5\r\nhello\r\n", "hello"},
+        {"bulk string with CRLF", "$7\r\nfoo\r\nba\r\n", "foo\r\nba"},
+        {"empty bulk string", "$0\r\n\r\n", ""},
+        {"nil bulk string", "$-1\r\n", nil},
+        {"nil array", "*-1\r\n", nil},
+        {
+            "pubsub message",
+            "*3\r\n$7\r\nmessage\r\n$4\r\nchat\r\n$13\r\n{\"room_id\":1}\r\n",
+            []interface{}{"message", "chat", `{"room_id":1}`},
+        },
+        {
+            "nested array",
+            "*2\r\n*1\r\n:1\r\n$-1\r\n",
+            []interface{}{[]interface{}{int64(1)}, nil},
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := readRedisReply(bufio.NewReader(strings.NewReader(tt.frame)))
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("got %#v, want %#v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestReadRedisReplyErrors(t *testing.T) {
+    frames := map[string]string{
+        "error reply":     "-ERR unknown command\r\n",
+        "unknown type":    "?what\r\n",
+        "bad bulk length": "$x\r\n",
+        "short bulk":      "$10\r\nabc\r\n",
+        "truncated array": "*2\r\n:1\r\n",
+        "empty line":      "\r\n",
+        "no line ending":  "+OK",
+    }
+
+    for name, frame := range frames {
+        t.Run(name, func(t *testing.T) {
+            if _, err := readRedisReply(bufio.NewReader(strings.NewReader(frame))); err == nil {
+                t.Errorf("no error reading %q", frame)
+            }
+        })
+    }
+}
+
+func TestWriteRedisCommand(t *testing.T) {
+    var sb strings.Builder
+    if err := writeRedisCommand(&sb, "PUBLISH", "chat", "a\r\nb"); err != nil {
+        t.Fatal(err)
+    }
+
+    want := "*3\r\n$7\r\nPUBLISH\r\n$4\r\nchat\r\n$4\r\na\r\nb\r\n"
+    if sb.String() != want {
+        t.Errorf("got %q, want %q", sb.String(), want)
+    }
+}
+
+// fakeRedis accepts connections and answers each command with the canned
+// frames returned by reply. Commands received are sent on commands.
+type fakeRedis struct {
+    listener net.Listener
+    commands chan []string
+    reply    func(conn net.Conn, cmd []string)
+}
+
+func newFakeRedis(t *testing.T, reply func(conn net.Conn, cmd []string)) *fakeRedis {
+    t.Helper()
+
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { listener.Close() })
+
+    f := &fakeRedis{listener: listener, commands: make(chan []string, 16), reply: reply}
+    go f.serve()
+    return f
+}
+
+func (f *fakeRedis) serve() {
+    for {
+        conn, err := f.listener.Accept()
+        if err != nil {
+            return
+        }
+        go func() {
+            defer conn.Close()
+            r := bufio.NewReader(conn)
+            for {
+                // Commands are RESP arrays of bulk strings
+                reply, err := readRedisReply(r)
+                if err != nil {
+                    return
+                }
+                var cmd []string
+                for _, arg := range reply.([]interface{}) {
+                    cmd = append(cmd, arg.(string))
+                }
+                f.commands <- cmd
+                f.reply(conn, cmd)
+            }
+        }()
+    }
+}
+
+// expect waits for the next command the fake server receives
+func (f *fakeRedis) expect(t *testing.T, want ...string) {
+    t.Helper()
+
+    select {
+    case cmd := <-f.commands:
+        if !reflect.DeepEqual(cmd, want) {
+            t.Fatalf("got command %q, want %q", cmd, want)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatalf("timed out waiting for %q", want)
+    }
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS message_read_status;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS room_members;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS users;
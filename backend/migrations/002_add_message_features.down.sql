@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_message_read_status_message_id;
+DROP INDEX IF EXISTS idx_message_read_status_user_id;
+DROP INDEX IF EXISTS idx_messages_content;
+
+-- updated_at is part of the initial messages table, so it stays
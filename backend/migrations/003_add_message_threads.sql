@@ -0,0 +1,6 @@
+-- Replies point at the message they answer; thread_root_id groups a whole thread
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS thread_root_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+
+-- Create index for paging through a thread
+CREATE INDEX IF NOT EXISTS idx_messages_thread_root_id ON messages(thread_root_id, created_at);
@@ -0,0 +1,12 @@
+-- Message Reactions table
+CREATE TABLE IF NOT EXISTS message_reactions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    message_id UUID REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    emoji VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE(message_id, user_id, emoji)
+);
+
+-- Create index for loading reactions with messages
+CREATE INDEX IF NOT EXISTS idx_message_reactions_message_id ON message_reactions(message_id);
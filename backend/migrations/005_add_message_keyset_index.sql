@@ -0,0 +1,2 @@
+-- Create index for keyset pagination over room history
+CREATE INDEX IF NOT EXISTS idx_messages_room_created_id ON messages(room_id, created_at DESC, id DESC);
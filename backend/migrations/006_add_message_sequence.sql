@@ -0,0 +1,17 @@
+-- Per-room message sequence numbers so reconnecting clients can catch up
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS seq BIGINT;
+
+-- Number existing messages in creation order
+WITH numbered AS (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY created_at, id) AS seq
+    FROM messages
+)
+UPDATE messages m SET seq = numbered.seq
+FROM numbered
+WHERE m.id = numbered.id AND m.seq IS NULL;
+
+UPDATE rooms r SET last_seq = COALESCE((SELECT MAX(seq) FROM messages WHERE room_id = r.id), 0);
+
+-- Create index for replaying a room from a sequence number
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_room_seq ON messages(room_id, seq);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_sessions_user_active;
+ALTER TABLE sessions DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
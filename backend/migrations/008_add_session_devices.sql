@@ -0,0 +1,6 @@
+-- Record where each session was started so users can tell devices apart
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip_address TEXT;
+
+-- Create index for listing a user's active sessions
+CREATE INDEX IF NOT EXISTS idx_sessions_user_active ON sessions(user_id, last_used_at DESC) WHERE revoked_at IS NULL;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_room_members_owner;
+
+-- Owners become admins again; moderators lose their extra rights
+UPDATE room_members SET role = 'admin' WHERE role = 'owner';
+UPDATE room_members SET role = 'member' WHERE role = 'moderator';
+
+ALTER TABLE room_members DROP CONSTRAINT IF EXISTS room_members_role_check;
+ALTER TABLE room_members ADD CONSTRAINT room_members_role_check
+    CHECK (role IN ('admin', 'member'));
@@ -0,0 +1,24 @@
+-- Room roles: owner > admin > moderator > member
+ALTER TABLE room_members DROP CONSTRAINT IF EXISTS room_members_role_check;
+ALTER TABLE room_members ADD CONSTRAINT room_members_role_check
+    CHECK (role IN ('owner', 'admin', 'moderator', 'member'));
+
+-- Room creators who are still members own their rooms
+UPDATE room_members rm SET role = 'owner'
+FROM rooms r
+WHERE rm.room_id = r.id AND rm.user_id = r.created_by AND rm.role <> 'owner';
+
+-- Rooms whose creator already left go to the longest-standing admin, or
+-- failing that the longest-standing member
+UPDATE room_members SET role = 'owner'
+WHERE id IN (
+    SELECT DISTINCT ON (rm.room_id) rm.id
+    FROM room_members rm
+    WHERE NOT EXISTS (
+        SELECT 1 FROM room_members o WHERE o.room_id = rm.room_id AND o.role = 'owner'
+    )
+    ORDER BY rm.room_id, (rm.role = 'admin') DESC, rm.joined_at, rm.id
+);
+
+-- A room has at most one owner
+CREATE UNIQUE INDEX IF NOT EXISTS idx_room_members_owner ON room_members(room_id) WHERE role = 'owner';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS room_invite_links;
+DROP TABLE IF EXISTS room_invitations;
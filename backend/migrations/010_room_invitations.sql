@@ -0,0 +1,28 @@
+-- Invitations to join a group room, answered by the invitee
+CREATE TABLE IF NOT EXISTS room_invitations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    inviter_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    invitee_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled')),
+    created_at TIMESTAMP DEFAULT NOW(),
+    responded_at TIMESTAMP
+);
+
+-- Shareable links anyone signed in can use to join a group room
+CREATE TABLE IF NOT EXISTS room_invite_links (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    code VARCHAR(32) NOT NULL UNIQUE,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP,
+    max_uses INTEGER CHECK (max_uses > 0),
+    use_count INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Create indexes for better performance
+CREATE UNIQUE INDEX IF NOT EXISTS idx_room_invitations_pending ON room_invitations(room_id, invitee_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_room_invitations_invitee ON room_invitations(invitee_id, created_at DESC) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_room_invite_links_room_id ON room_invite_links(room_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS room_bans;
+DROP INDEX IF EXISTS idx_rooms_public;
+ALTER TABLE rooms DROP CONSTRAINT IF EXISTS rooms_visibility_check;
+ALTER TABLE rooms DROP COLUMN IF EXISTS visibility;
@@ -0,0 +1,18 @@
+-- Public group rooms can be discovered and joined without an invitation
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS visibility VARCHAR(20) NOT NULL DEFAULT 'private';
+ALTER TABLE rooms DROP CONSTRAINT IF EXISTS rooms_visibility_check;
+ALTER TABLE rooms ADD CONSTRAINT rooms_visibility_check
+    CHECK (visibility IN ('public', 'private') AND (type = 'group' OR visibility = 'private'));
+
+-- Users blocked from joining a room
+CREATE TABLE IF NOT EXISTS room_bans (
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    banned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT,
+    created_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (room_id, user_id)
+);
+
+-- Create index for room discovery
+CREATE INDEX IF NOT EXISTS idx_rooms_public ON rooms(created_at DESC) WHERE visibility = 'public';
@@ -0,0 +1,6 @@
+-- Point messages back at the public upload paths
+UPDATE messages m SET file_url = '/uploads/' || a.storage_key
+FROM attachments a
+WHERE m.file_url = '/api/attachments/' || a.id;
+
+DROP TABLE IF EXISTS attachments;
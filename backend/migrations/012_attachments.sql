@@ -0,0 +1,30 @@
+-- Uploaded files, tied to their uploader and, once sent, to a room
+CREATE TABLE IF NOT EXISTS attachments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    uploader_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    storage_key TEXT NOT NULL UNIQUE,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Create indexes for attachments
+CREATE INDEX IF NOT EXISTS idx_attachments_uploader ON attachments(uploader_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_room ON attachments(room_id, created_at DESC);
+
+-- Files sent before attachments existed were served publicly from
+-- /uploads; record them and point their messages at the checked download
+INSERT INTO attachments (uploader_id, room_id, message_id, storage_key, file_name, size, created_at)
+SELECT m.sender_id, m.room_id, m.id, substring(m.file_url from 10),
+       COALESCE(NULLIF(m.file_name, ''), substring(m.file_url from 10)),
+       COALESCE(m.file_size, 0), m.created_at
+FROM messages m
+WHERE m.file_url LIKE '/uploads/_%' AND m.sender_id IS NOT NULL
+ON CONFLICT (storage_key) DO NOTHING;
+
+UPDATE messages m SET file_url = '/api/attachments/' || a.id
+FROM attachments a
+WHERE a.message_id = m.id AND m.file_url LIKE '/uploads/%';
@@ -0,0 +1,7 @@
+ALTER TABLE messages DROP COLUMN IF EXISTS thumbnails;
+ALTER TABLE messages DROP COLUMN IF EXISTS height;
+ALTER TABLE messages DROP COLUMN IF EXISTS width;
+
+ALTER TABLE attachments DROP COLUMN IF EXISTS thumbnails;
+ALTER TABLE attachments DROP COLUMN IF EXISTS height;
+ALTER TABLE attachments DROP COLUMN IF EXISTS width;
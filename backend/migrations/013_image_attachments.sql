@@ -0,0 +1,9 @@
+-- Dimensions and thumbnails of image attachments, copied onto the messages
+-- that send them
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS width INTEGER;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS height INTEGER;
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnails JSONB;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS width INTEGER;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS height INTEGER;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS thumbnails JSONB;
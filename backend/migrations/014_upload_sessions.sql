@@ -0,0 +1,26 @@
+-- Chunked uploads in progress; chunks are stored as separate objects until
+-- the upload is completed
+CREATE TABLE IF NOT EXISTS uploads (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_name TEXT NOT NULL,
+    size BIGINT NOT NULL CHECK (size > 0),
+    received BIGINT NOT NULL DEFAULT 0,
+    checksum TEXT,
+    hash_state BYTEA,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'completed')),
+    attachment_id UUID REFERENCES attachments(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS upload_chunks (
+    upload_id UUID REFERENCES uploads(id) ON DELETE CASCADE,
+    position BIGINT NOT NULL,
+    size BIGINT NOT NULL,
+    storage_key TEXT NOT NULL,
+    PRIMARY KEY (upload_id, position)
+);
+
+-- Create index for expiring abandoned uploads
+CREATE INDEX IF NOT EXISTS idx_uploads_expires_at ON uploads(expires_at);
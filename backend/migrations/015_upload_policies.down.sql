@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS quarantined_files;
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS storage_quota;
+ALTER TABLE users DROP COLUMN IF EXISTS storage_quota;
@@ -0,0 +1,19 @@
+-- Storage limits for one user's uploads and for the files sent to one
+-- room; NULL uses the server's default
+ALTER TABLE users ADD COLUMN IF NOT EXISTS storage_quota BIGINT;
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS storage_quota BIGINT;
+
+-- Uploads the malware scanner flagged, kept out of reach for review
+CREATE TABLE IF NOT EXISTS quarantined_files (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    uploader_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    storage_key TEXT NOT NULL UNIQUE,
+    file_name TEXT NOT NULL,
+    content_type TEXT NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
+    signature TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Create index for quarantined files
+CREATE INDEX IF NOT EXISTS idx_quarantined_files_uploader ON quarantined_files(uploader_id);
@@ -0,0 +1,2 @@
+-- Partial index for paging through a room's files
+CREATE INDEX IF NOT EXISTS idx_messages_room_files ON messages(room_id, created_at DESC, id DESC) WHERE file_url IS NOT NULL;
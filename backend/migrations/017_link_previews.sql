@@ -0,0 +1,13 @@
+-- Preview of the page a message links to, added once it has been fetched
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS link_preview JSONB;
+
+-- Fetched previews by URL; a NULL preview means the page has none
+CREATE TABLE IF NOT EXISTS link_previews (
+    url TEXT PRIMARY KEY,
+    preview JSONB,
+    fetched_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+-- Create index for expiring cached previews
+CREATE INDEX IF NOT EXISTS idx_link_previews_expires_at ON link_previews(expires_at);
@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL schema migrations so the server binary
+// can apply them itself.
+//
+// Each migration is a NNN_name.sql file applied in version order, with an
+// optional NNN_name.down.sql file that reverts it.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -1,14 +1,50 @@
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 import { format } from 'date-fns';
 import { File, CheckCheck } from 'lucide-react';
 import Avatar from '../ui/Avatar';
 import Input from "../ui/Input";
 import { useAuthStore } from '../../store/authStore';
+import { fileAPI } from '../../services/api';
+
+const SERVER_URL = 'http://localhost:8080';
+
+// Attachments need a signed link before the browser can load them
+const useAttachmentUrl = (fileUrl, size) => {
+  const [url, setUrl] = useState(null);
+
+  useEffect(() => {
+    const match = fileUrl?.match(/^\/api\/attachments\/([0-9a-f-]+)$/i);
+    if (!match) {
+      setUrl(fileUrl ? `${SERVER_URL}${fileUrl}` : null);
+      return;
+    }
+
+    let cancelled = false;
+    fileAPI
+      .getSignedUrl(match[1], size)
+      .then((response) => {
+        if (!cancelled) setUrl(`${SERVER_URL}${response.data.url}`);
+      })
+      .catch(() => {
+        if (!cancelled) setUrl(null);
+      });
+
+    return () => {
+      cancelled = true;
+    };
+  }, [fileUrl, size]);
+
+  return url;
+};
 
 const MessageItem = ({ message }) => {
   const currentUser = useAuthStore((state) => state.user);
   const isSent = message.sender_id === currentUser?.id;
   const isSystem = message.type === 'join' || message.type === 'leave';
+  const fullUrl = useAttachmentUrl(message.file_url);
+  // Chat shows the mid-sized thumbnail when the image has one
+  const previewSize = message.thumbnails?.find((t) => t.size === 480)?.size;
+  const previewUrl = useAttachmentUrl(previewSize ? message.file_url : null, previewSize);
 
   if (isSystem) {
     return (
@@ -25,15 +61,15 @@ const MessageItem = ({ message }) => {
   };
 
   const renderFilePreview = () => {
-    if (message.type === 'file' && message.file_url) {
-      const isImage = /\.(jpg|jpeg|png|gif|webp)$/i.test(message.file_url);
-      const fullUrl = `http://localhost:8080${message.file_url}`;
-      
-      if (isImage) {
+    if ((message.type === 'file' || message.type === 'image') && message.file_url) {
+      const isImage =
+        message.type === 'image' || /\.(jpg|jpeg|png|gif|webp)$/i.test(message.file_name || message.file_url);
+
+      if (isImage && fullUrl) {
         return (
           <div className="mb-2 rounded-lg overflow-hidden">
             <img 
-              src={fullUrl}
+              src={previewUrl || fullUrl}
               alt={message.file_name}
               className="max-w-xs max-h-64 object-cover cursor-pointer hover:opacity-90 transition-opacity"
               onClick={() => window.open(fullUrl, '_blank')}
@@ -43,7 +79,7 @@ const MessageItem = ({ message }) => {
       } else {
         return (
           <a
-            href={fullUrl}
+            href={fullUrl || undefined}
             target="_blank"
             rel="noopener noreferrer"
             className="flex items-center space-x-2 mb-2 p-3 bg-gray-50 rounded-lg hover:bg-gray-100 transition-colors">
@@ -89,6 +125,33 @@ const MessageItem = ({ message }) => {
               {message.content}
             </p>
           )}
+
+          {message.link_preview && (
+            <a
+              href={message.link_preview.url}
+              target="_blank"
+              rel="noopener noreferrer"
+              className="block mt-2 rounded-lg overflow-hidden border border-black/10 bg-white/10 hover:bg-white/20"
+            >
+              {message.link_preview.image_url && (
+                <img
+                  src={message.link_preview.image_url}
+                  alt=""
+                  referrerPolicy="no-referrer"
+                  className="w-full max-h-40 object-cover"
+                />
+              )}
+              <div className="p-2">
+                {message.link_preview.site_name && (
+                  <p className="text-xs opacity-70">{message.link_preview.site_name}</p>
+                )}
+                <p className="text-sm font-semibold">{message.link_preview.title}</p>
+                {message.link_preview.description && (
+                  <p className="text-xs opacity-80 line-clamp-2">{message.link_preview.description}</p>
+                )}
+              </div>
+            </a>
+          )}
           
           <div className={`flex items-center justify-end space-x-1 mt-1 ${isSent ? 'text-white/70' : 'text-gray-500'}`}>
             <span className="text-xs">
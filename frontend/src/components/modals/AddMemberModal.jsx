@@ -99,11 +99,11 @@ const AddMemberModal = ({ isOpen, onClose, roomId, currentMembers }) => {
       for (const userId of selectedUsers) {
         await roomAPI.addMember(roomId, userId);
       }
-      toast.success(`Added ${selectedUsers.length} member(s) successfully`);
+      toast.success(`Invited ${selectedUsers.length} member(s) successfully`);
       onClose();
       setSelectedUsers([]);
     } catch {
-      toast.error("Failed to add members");
+      toast.error("Failed to invite members");
     } finally {
       setIsLoading(false);
     }
@@ -44,7 +44,7 @@ const MembersModal = ({ isOpen, onClose, members, room, onAddMember }) => {
                     <h4 className="font-semibold text-gray-900 truncate">
                       {member.username}
                     </h4>
-                    {member.id === room?.created_by && (
+                    {member.role === "owner" && (
                       <Crown className="w-4 h-4 text-yellow-500" />
                     )}
                   </div>
@@ -10,8 +10,10 @@ export const useWebSocket = (roomId) => {
   const [isConnected, setIsConnected] = useState(false);
   const token = useAuthStore((state) => state.token);
   const addMessage = useChatStore((state) => state.addMessage);
+  const updateMessage = useChatStore((state) => state.updateMessage);
   const addTypingUser = useChatStore((state) => state.addTypingUser);
   const removeTypingUser = useChatStore((state) => state.removeTypingUser);
+  const removeRoom = useChatStore((state) => state.removeRoom);
 
   const connect = useCallback(() => {
     if (!roomId || !token) return;
@@ -38,6 +40,19 @@ export const useWebSocket = (roomId) => {
         case "file":
           addMessage(data);
           break;
+        case "message_edited":
+        case "message_deleted":
+          // An edit drops the old link preview; a new one may follow
+          updateMessage(data.message_id, {
+            content: data.content,
+            link_preview: data.link_preview,
+            updated_at: data.updated_at,
+          });
+          break;
+        case "message_updated":
+          // Link previews arrive shortly after the message itself
+          updateMessage(data.message_id, { link_preview: data.link_preview });
+          break;
         case "typing":
           if (data.is_typing) {
             addTypingUser(data.user_id, data.username);
@@ -50,6 +65,17 @@ export const useWebSocket = (roomId) => {
         case "leave":
           addMessage(data);
           break;
+        case "room_removed":
+          // Left, removed or deleted, possibly from another device
+          removeRoom(data.room_id);
+          break;
+        case "session_revoked":
+          // This device was signed out elsewhere; don't reconnect
+          localStorage.removeItem("token");
+          localStorage.removeItem("refresh_token");
+          localStorage.removeItem("user");
+          window.location.href = "/auth";
+          break;
         default:
           break;
       }
@@ -71,7 +97,7 @@ export const useWebSocket = (roomId) => {
     };
 
     return ws;
-  }, [roomId, token, addMessage, addTypingUser, removeTypingUser]);
+  }, [roomId, token, addMessage, updateMessage, addTypingUser, removeTypingUser, removeRoom]);
 
   useEffect(() => {
     connectRef.current = connect;
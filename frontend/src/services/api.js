@@ -22,14 +22,53 @@ api.interceptors.request.use(
   }
 );
 
+const clearSession = () => {
+  localStorage.removeItem("token");
+  localStorage.removeItem("refresh_token");
+  localStorage.removeItem("user");
+  window.location.href = "/auth";
+};
+
+// Concurrent 401s share a single refresh request
+let refreshPromise = null;
+
+const refreshAccessToken = () => {
+  if (!refreshPromise) {
+    const refreshToken = localStorage.getItem("refresh_token");
+    refreshPromise = axios
+      .post(`${API_BASE_URL}/auth/refresh`, { refresh_token: refreshToken })
+      .then((response) => {
+        const { token, refresh_token } = response.data;
+        localStorage.setItem("token", token);
+        localStorage.setItem("refresh_token", refresh_token);
+        return token;
+      })
+      .finally(() => {
+        refreshPromise = null;
+      });
+  }
+  return refreshPromise;
+};
+
 // Response interceptor
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
+    const original = error.config;
     if (error.response?.status === 401) {
-      localStorage.removeItem("token");
-      localStorage.removeItem("user");
-      window.location.href = "/auth";
+      // Retry once with a fresh access token before giving up
+      if (!original._retried && localStorage.getItem("refresh_token")) {
+        original._retried = true;
+        try {
+          const token = await refreshAccessToken();
+          original.headers.Authorization = `Bearer ${token}`;
+          return api(original);
+        } catch {
+          clearSession();
+        }
+      } else {
+        clearSession();
+      }
     }
     return Promise.reject(error);
   }
@@ -39,6 +78,16 @@ api.interceptors.response.use(
 export const authAPI = {
   register: (data) => api.post("/auth/register", data),
   login: (data) => api.post("/auth/login", data),
+  refresh: (refreshToken) =>
+    api.post("/auth/refresh", { refresh_token: refreshToken }),
+  getSessions: () => api.get("/auth/sessions"),
+  terminateSession: (sessionId) => api.delete(`/auth/sessions/${sessionId}`),
+  // The token is passed explicitly because local storage is cleared
+  // before the request interceptor runs
+  logout: (token) =>
+    api.post("/auth/logout", null, {
+      headers: { Authorization: `Bearer ${token}` },
+    }),
 };
 
 // User APIs
@@ -51,6 +100,21 @@ export const userAPI = {
   updateUserProfile: (data) => api.put("/users/me", data),
 };
 
+// Invitation APIs
+export const invitationAPI = {
+  getMyInvitations: () => api.get("/invitations"),
+  accept: (invitationId) => api.post(`/invitations/${invitationId}/accept`),
+  decline: (invitationId) => api.post(`/invitations/${invitationId}/decline`),
+  acceptInviteLink: (code) => api.post(`/invites/${code}/accept`),
+};
+
+// Search APIs
+export const searchAPI = {
+  // filters: room_id, sender_id, from, to, type, limit, offset
+  searchMessages: (query, filters = {}) =>
+    api.get("/search/messages", { params: { q: query, ...filters } }),
+};
+
 // Room APIs
 export const roomAPI = {
   getUserRooms: () => api.get("/rooms"),
@@ -60,20 +124,48 @@ export const roomAPI = {
   getRoom: (roomId) => api.get(`/rooms/${roomId}`),
   updateRoom: (roomId, data) => api.put(`/rooms/${roomId}`, data),
   deleteRoom: (roomId) => api.delete(`/rooms/${roomId}`),
-  getRoomMessages: (roomId, limit = 50, offset = 0) =>
-    api.get(`/rooms/${roomId}/messages?limit=${limit}&offset=${offset}`),
-  searchMessages: (roomId, query, limit = 50, offset = 0) =>
+  getRoomMessages: (roomId, limit = 50, before = "") =>
+    api.get(
+      `/rooms/${roomId}/messages?limit=${limit}${
+        before ? `&before=${encodeURIComponent(before)}` : ""
+      }`
+    ),
+  searchMessages: (roomId, query, limit = 50, before = "") =>
     api.get(
       `/rooms/${roomId}/messages/search?q=${encodeURIComponent(
         query
-      )}&limit=${limit}&offset=${offset}`
+      )}&limit=${limit}${before ? `&before=${encodeURIComponent(before)}` : ""}`
     ),
+  // filters: type (image, document or other), sender_id, from, to, before
+  getRoomFiles: (roomId, filters = {}) =>
+    api.get(`/rooms/${roomId}/files`, { params: filters }),
   markRoomAsRead: (roomId) => api.post(`/rooms/${roomId}/read`),
   getRoomMembers: (roomId) => api.get(`/rooms/${roomId}/members`),
   addMember: (roomId, userId) =>
     api.post(`/rooms/${roomId}/members`, { user_id: userId }),
   removeMember: (roomId, userId) =>
     api.delete(`/rooms/${roomId}/members/${userId}`),
+  updateMemberRole: (roomId, userId, role) =>
+    api.put(`/rooms/${roomId}/members/${userId}/role`, { role }),
+  getInvitations: (roomId) => api.get(`/rooms/${roomId}/invitations`),
+  cancelInvitation: (roomId, invitationId) =>
+    api.delete(`/rooms/${roomId}/invitations/${invitationId}`),
+  getInviteLinks: (roomId) => api.get(`/rooms/${roomId}/invite-links`),
+  createInviteLink: (roomId, data) =>
+    api.post(`/rooms/${roomId}/invite-links`, data),
+  revokeInviteLink: (roomId, linkId) =>
+    api.delete(`/rooms/${roomId}/invite-links/${linkId}`),
+  discoverRooms: (query = "", limit = 20, offset = 0) =>
+    api.get(
+      `/rooms/discover?q=${encodeURIComponent(
+        query
+      )}&limit=${limit}&offset=${offset}`
+    ),
+  joinRoom: (roomId) => api.post(`/rooms/${roomId}/join`),
+  getBans: (roomId) => api.get(`/rooms/${roomId}/bans`),
+  banUser: (roomId, userId, reason) =>
+    api.post(`/rooms/${roomId}/bans`, { user_id: userId, reason }),
+  unbanUser: (roomId, userId) => api.delete(`/rooms/${roomId}/bans/${userId}`),
   leaveRoom: (roomId) => api.post(`/rooms/${roomId}/leave`),
 };
 
@@ -95,6 +187,10 @@ export const fileAPI = {
       },
     });
   },
+  // Short-lived link usable in <img> tags and plain anchors
+  getSignedUrl: (attachmentId, size) =>
+    api.get(`/attachments/${attachmentId}/url`, { params: size ? { size } : {} }),
+  getStorageUsage: () => api.get("/users/me/storage"),
 };
 
 export default api;
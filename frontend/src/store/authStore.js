@@ -12,9 +12,10 @@ export const useAuthStore = create((set) => ({
     set({ isLoading: true, error: null });
     try {
       const response = await authAPI.login(credentials);
-      const { token, user } = response.data;
+      const { token, refresh_token, user } = response.data;
       
       localStorage.setItem('token', token);
+      localStorage.setItem('refresh_token', refresh_token);
       localStorage.setItem('user', JSON.stringify(user));
       
       set({ user, token, isLoading: false });
@@ -32,9 +33,10 @@ export const useAuthStore = create((set) => ({
     set({ isLoading: true, error: null });
     try {
       const response = await authAPI.register(data);
-      const { token, user } = response.data;
+      const { token, refresh_token, user } = response.data;
       
       localStorage.setItem('token', token);
+      localStorage.setItem('refresh_token', refresh_token);
       localStorage.setItem('user', JSON.stringify(user));
       
       set({ user, token, isLoading: false });
@@ -49,7 +51,10 @@ export const useAuthStore = create((set) => ({
   },
 
   logout: () => {
+    // Revoke the session server-side; local state is cleared either way
+    authAPI.logout(localStorage.getItem('token')).catch(() => {});
     localStorage.removeItem('token');
+    localStorage.removeItem('refresh_token');
     localStorage.removeItem('user');
     set({ user: null, token: null });
     toast.success('Logged out successfully');
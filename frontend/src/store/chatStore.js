@@ -29,7 +29,7 @@ export const useChatStore = create((set) => ({
         roomAPI.getRoomMembers(room.id),
       ]);
       set({ 
-        messages: messagesRes.data || [], 
+        messages: messagesRes.data?.messages || [], 
         members: membersRes.data || [],
         isLoading: false 
       });
@@ -51,12 +51,32 @@ export const useChatStore = create((set) => ({
     }
   },
 
+  removeRoom: (roomId) => {
+    set((state) => {
+      const leaving = state.currentRoom?.id === roomId;
+      return {
+        rooms: state.rooms.filter((room) => room.id !== roomId),
+        currentRoom: leaving ? null : state.currentRoom,
+        messages: leaving ? [] : state.messages,
+        members: leaving ? [] : state.members,
+      };
+    });
+  },
+
   addMessage: (message) => {
     set((state) => ({
       messages: [...state.messages, message],
     }));
   },
 
+  updateMessage: (messageId, changes) => {
+    set((state) => ({
+      messages: state.messages.map((message) =>
+        message.id === messageId ? { ...message, ...changes } : message
+      ),
+    }));
+  },
+
   addTypingUser: (userId, username) => {
     set((state) => {
       const newTypingUsers = new Set(state.typingUsers);